@@ -18,6 +18,7 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package config
 
 import (
+	"flag"
 	"math/big"
 	"time"
 )
@@ -48,6 +49,75 @@ func OptionStruct(rt ReaderType, st interface{}) OptionFunc {
 	}
 }
 
+// OptionLayerFile 添加配置文件层Option函数, 后添加的层优先级更高
+func OptionLayerFile(name, filename string) OptionFunc {
+	return func(c *AdapterConfig) {
+		c.sources = append(c.sources, &configLayer{name: name, file: filename})
+	}
+}
+
+// OptionDir 添加目录配置层Option函数, 按文件名顺序加载目录中匹配glob的文件, 每个文件为一层, 层名为文件路径
+func OptionDir(path, glob string) OptionFunc {
+	return func(c *AdapterConfig) {
+		c.sources = append(c.sources, &configLayer{name: path, dir: path, glob: glob})
+	}
+}
+
+// OptionLayerString 添加字符串配置层Option函数, 后添加的层优先级更高
+func OptionLayerString(name string, rt ReaderType, cStr string) OptionFunc {
+	return func(c *AdapterConfig) {
+		c.sources = append(c.sources, &configLayer{name: name, readerType: rt, str: cStr})
+	}
+}
+
+// OptionLayerStruct 添加结构体配置层Option函数, 后添加的层优先级更高
+func OptionLayerStruct(name string, rt ReaderType, st interface{}) OptionFunc {
+	return func(c *AdapterConfig) {
+		c.sources = append(c.sources, &configLayer{name: name, readerType: rt, st: st})
+	}
+}
+
+// OptionLayerOptions 添加键值配置层Option函数, 常用于内置默认值, 后添加的层优先级更高
+func OptionLayerOptions(name string, opts Options) OptionFunc {
+	return func(c *AdapterConfig) {
+		values := map[string]interface{}(opts)
+		if values == nil {
+			values = make(map[string]interface{})
+		}
+		c.sources = append(c.sources, &configLayer{name: name, values: values})
+	}
+}
+
+// OptionReaderOptions 设置解析配置时读取器的Option函数, 例如xml属性的前缀
+func OptionReaderOptions(opts ...ReaderOptionFunc) OptionFunc {
+	return func(c *AdapterConfig) {
+		c.readerOptions = append(c.readerOptions, opts...)
+	}
+}
+
+// OptionSchema 设置JSON Schema, 加载和重新加载时校验替换${...}后的配置, 不通过则返回全部错误
+func OptionSchema(schema []byte) OptionFunc {
+	return func(c *AdapterConfig) {
+		c.schemaData = schema
+	}
+}
+
+// OptionStrict 开启严格模式, 配置中存在target结构体(或未设置时为Schema)中未定义的键时加载失败, 并提示相近的键,
+// ToObject时校验目标结构体
+func OptionStrict(target interface{}) OptionFunc {
+	return func(c *AdapterConfig) {
+		c.strict = true
+		c.strictTarget = target
+	}
+}
+
+// OptionSaveBackup Save时将原配置文件备份为.bak文件
+func OptionSaveBackup() OptionFunc {
+	return func(c *AdapterConfig) {
+		c.saveBackup = true
+	}
+}
+
 // OptionENVAllowed 允许获取系统环境变量
 func OptionENVAllowed() OptionFunc {
 	return func(c *AdapterConfig) {
@@ -62,6 +132,51 @@ func OptionENVPrefix(prefix string) OptionFunc {
 	}
 }
 
+// OptionEnvFile 读取.env文件中的变量, 开启EnvAllowed时用于替换${VAR}, 系统环境变量优先, 后添加的文件优先级更高
+func OptionEnvFile(filenames ...string) OptionFunc {
+	return func(c *AdapterConfig) {
+		c.envFiles = append(c.envFiles, filenames...)
+	}
+}
+
+// OptionEnvOverlay 开启环境变量覆盖, 每个键都可被环境变量覆盖, 例如db.pool.size对应APP_DB_POOL_SIZE(EnvPrefix为APP),
+// separator为分隔符, 默认为"_", 值转换为原值的类型, 列表支持逗号分隔或者下标, 例如APP_SERVERS_0_HOST
+func OptionEnvOverlay(separator string) OptionFunc {
+	return func(c *AdapterConfig) {
+		c.envOverlay = true
+		c.envSeparator = separator
+	}
+}
+
+// OptionEnvNormalizer 设置环境变量覆盖时键的转换函数, 默认转为大写并将"-"和"."替换为"_"
+func OptionEnvNormalizer(fn func(segment string) string) OptionFunc {
+	return func(c *AdapterConfig) {
+		c.envNormalizer = fn
+	}
+}
+
+// OptionFlagSet 绑定命令行参数, 参数名为键, 例如--db.pool.size=20, 只有显式设置的参数覆盖配置, 优先级高于环境变量,
+// 只绑定DefineFlags定义的参数和配置中已存在的键, 需在fs.Parse之后调用NewConfigOptions
+func OptionFlagSet(fs *flag.FlagSet) OptionFunc {
+	return func(c *AdapterConfig) {
+		c.flagSet = fs
+	}
+}
+
+// OptionWatch 开启配置文件监听, 按interval轮询文件的修改时间和内容, 变化时重新加载配置
+func OptionWatch(interval time.Duration) OptionFunc {
+	return func(c *AdapterConfig) {
+		c.watchInterval = interval
+	}
+}
+
+// OptionWatchErrorHandler 设置监听重新加载失败的回调函数, 失败时保留上一次正确的配置
+func OptionWatchErrorHandler(fn func(error)) OptionFunc {
+	return func(c *AdapterConfig) {
+		c.watchErrorHandler = fn
+	}
+}
+
 // Config manager data functions
 type Config interface {
 	// get a object
@@ -106,6 +221,79 @@ type Config interface {
 	Copy() Config
 }
 
+// Getter getters which return errors, implemented by *AdapterConfig
+type Getter interface {
+	// get time by time value or string, exp: 2006-01-02T15:04:05Z07:00, 2006-01-02
+	GetTime(key string, defValue ...time.Time) time.Time
+	// get a value and whether it exists
+	Lookup(key string) (interface{}, bool)
+	// get a object, or KeyNotFoundError, KeyTypeError
+	GetInterfaceE(key string) (interface{}, error)
+	// get a string, or KeyNotFoundError, KeyTypeError
+	GetStringE(key string) (string, error)
+	// get a bool, or KeyNotFoundError, KeyTypeError, KeyParseError
+	GetBooleanE(key string) (bool, error)
+	// get a int, or KeyNotFoundError, KeyTypeError, KeyParseError
+	GetIntE(key string) (int, error)
+	// get a float, or KeyNotFoundError, KeyTypeError, KeyParseError
+	GetFloatE(key string) (float64, error)
+	// get time duration, or KeyNotFoundError, KeyTypeError, KeyParseError
+	GetTimeDurationE(key string) (time.Duration, error)
+	// get time, or KeyNotFoundError, KeyTypeError, KeyParseError
+	GetTimeE(key string) (time.Time, error)
+	// get byte size, or KeyNotFoundError, KeyTypeError, KeyParseError
+	GetByteSizeE(key string) (*big.Int, error)
+	// get list, or KeyNotFoundError, KeyTypeError
+	GetListE(key string) ([]interface{}, error)
+	// get map, or KeyNotFoundError, KeyTypeError
+	GetMapE(key string) (Options, error)
+}
+
+// Inspector where keys' values come from, implemented by *AdapterConfig
+type Inspector interface {
+	// get the name of the layer which supplies key's value
+	LayerOf(key string) string
+	// get the position where key's value is defined
+	Origin(key string) (Position, bool)
+	// get the keys defined by more than one layer
+	Conflicts() []KeyConflict
+	// get the environment variables which override keys' values
+	EnvOverrides() []EnvOverride
+}
+
+// Validator validator of keys' values, implemented by *AdapterConfig
+type Validator interface {
+	// validate keys' values with rules, exp: {"server.port": "required,min=1,max=65535"}
+	Validate(rules map[string]string) error
+}
+
+// Saver writer of config files, implemented by *AdapterConfig
+type Saver interface {
+	// write the values set by SetKeyValue back into the config file
+	Save() error
+	// write the config file with the values set by SetKeyValue into path
+	SaveAs(path string) error
+}
+
+// Reloader reloader of configs from sources, implemented by *AdapterConfig
+type Reloader interface {
+	// reload configs from sources
+	Reload() error
+	// stop watching config files
+	StopWatch()
+	// subscribe changes of keys under prefix
+	OnChange(prefix string, fn func(ChangeEvent)) (cancel func())
+}
+
+var (
+	_ Config    = (*AdapterConfig)(nil)
+	_ Getter    = (*AdapterConfig)(nil)
+	_ Inspector = (*AdapterConfig)(nil)
+	_ Validator = (*AdapterConfig)(nil)
+	_ Saver     = (*AdapterConfig)(nil)
+	_ Reloader  = (*AdapterConfig)(nil)
+)
+
 // NewConfig return Config by file's path, judge path's suffix, supported .json, .yml, .yaml
 func NewConfig(name string) (Config, error) {
 	if len(name) == 0 {
@@ -18,6 +18,8 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package config
 
 import (
+	"errors"
+	"flag"
 	"math/big"
 	"reflect"
 	"strings"
@@ -25,12 +27,6 @@ import (
 	"time"
 
 	"github.com/iTrellis/common/formats"
-	"github.com/iTrellis/common/json"
-	"gopkg.in/yaml.v3"
-)
-
-const (
-	includeReg = `\$\{([0-9|a-z|A-Z|_|-]|\.)+\}`
 )
 
 // AdapterConfig default config adapter
@@ -42,10 +38,41 @@ type AdapterConfig struct {
 	EnvPrefix  string
 	EnvAllowed bool
 
+	envFiles []string
+	dotEnv   map[string]string
+
+	envOverlay    bool
+	envSeparator  string
+	envNormalizer func(string) string
+
+	flagSet *flag.FlagSet
+
 	data []byte
 
 	readerType ReaderType
 
+	readerOptions []ReaderOptionFunc
+
+	sources []*configLayer
+	layers  []*configLayer
+	runtime []keyValue
+
+	schemaData []byte
+	schema     *jsonSchema
+
+	strict       bool
+	strictTarget interface{}
+
+	saveBackup bool
+
+	watchInterval     time.Duration
+	watchErrorHandler func(error)
+	watchStop         chan struct{}
+
+	subLocker   sync.Mutex
+	subID       int
+	subscribers map[int]*changeSubscriber
+
 	reader  Reader
 	locker  sync.RWMutex
 	configs map[string]interface{}
@@ -75,41 +102,74 @@ func (p *AdapterConfig) init(opts ...OptionFunc) (err error) {
 		opts[i](p)
 	}
 
-	if len(p.ConfigFile) > 0 {
-
-		p.readerType = fileToReaderType(p.ConfigFile)
+	if len(p.ConfigFile) > 0 || len(p.ConfigString) > 0 || p.ConfigStruct != nil || len(p.sources) == 0 {
+		name := p.ConfigFile
+		if len(name) == 0 {
+			name = "config"
+		}
+		base := &configLayer{
+			name:       name,
+			file:       p.ConfigFile,
+			str:        p.ConfigString,
+			st:         p.ConfigStruct,
+			readerType: p.readerType,
+		}
+		p.sources = append([]*configLayer{base}, p.sources...)
+	}
 
-		p.data, _, err = filesRepo.Read(p.ConfigFile)
-		if err != nil {
+	if p.schemaData != nil {
+		if p.schema, err = parseSchema(p.schemaData); err != nil {
 			return
 		}
 	}
 
-	switch p.readerType {
-	case ReaderTypeJSON:
-		p.reader = NewJSONReader(ReaderOptionFilename(p.ConfigFile))
-	case ReaderTypeYAML:
-		p.reader = NewYAMLReader(ReaderOptionFilename(p.ConfigFile))
-	default:
-		return ErrNotSupportedReaderType
+	if p.dotEnv, err = loadDotEnv(p.envFiles); err != nil {
+		return
 	}
 
-	if len(p.ConfigString) > 0 {
-		p.data = []byte(p.ConfigString)
+	p.layers, err = loadLayers(p.sources, p.readerOptions)
+	if err != nil {
+		return
 	}
-
-	if p.ConfigStruct != nil {
-		p.data, err = p.reader.Dump(p.ConfigStruct)
-		if err != nil {
-			return err
-		}
+	if p.layers, err = p.appendEnvLayer(p.layers); err != nil {
+		return
 	}
+	if p.layers, err = p.appendFlagLayer(p.layers); err != nil {
+		return
+	}
+
+	primary := primaryLayer(p.layers)
+	p.readerType, p.reader, p.data = primary.readerType, primary.reader, primary.data
 
-	if err = p.reader.ParseData(p.data, &p.configs); err != nil {
+	if p.configs, err = p.build(p.layers); err != nil {
 		return
 	}
 
-	return p.copyDollarSymbol("", &p.configs)
+	if p.watchInterval > 0 {
+		p.startWatch()
+	}
+	return nil
+}
+
+// build merge the layers and runtime values, then replace the ${...} values,
+// and validate them with the schema and strict target
+func (p *AdapterConfig) build(layers []*configLayer) (map[string]interface{}, error) {
+	configs, err := mergeLayers(layers, p.runtime)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.copyDollarSymbol(configs); err != nil {
+		return nil, annotateError(layers, p.runtime, err)
+	}
+	if p.schema != nil {
+		if err := p.schema.validate(configs); err != nil {
+			return nil, annotateError(layers, p.runtime, err)
+		}
+	}
+	if err := p.checkStrict(configs); err != nil {
+		return nil, err
+	}
+	return configs, nil
 }
 
 // GetKeys get map keys
@@ -132,20 +192,49 @@ func (p *AdapterConfig) copy() *AdapterConfig {
 
 	valuesMap := values.(map[string]interface{})
 	return &AdapterConfig{
-		ConfigFile:   p.ConfigFile,
-		ConfigString: p.ConfigString,
-		ConfigStruct: p.ConfigStruct,
-		readerType:   p.readerType,
-		reader:       p.reader,
-		configs:      valuesMap,
+		ConfigFile:    p.ConfigFile,
+		ConfigString:  p.ConfigString,
+		ConfigStruct:  p.ConfigStruct,
+		EnvPrefix:     p.EnvPrefix,
+		EnvAllowed:    p.EnvAllowed,
+		envFiles:      p.envFiles,
+		dotEnv:        p.dotEnv,
+		envOverlay:    p.envOverlay,
+		envSeparator:  p.envSeparator,
+		envNormalizer: p.envNormalizer,
+		flagSet:       p.flagSet,
+		readerType:    p.readerType,
+		readerOptions: p.readerOptions,
+		sources:       p.sources,
+		layers:        p.layers,
+		runtime:       copyKeyValues(p.runtime),
+		schema:        p.schema,
+		strict:        p.strict,
+		strictTarget:  p.strictTarget,
+		saveBackup:    p.saveBackup,
+		reader:        p.reader,
+		configs:       valuesMap,
 	}
 }
 
 // GetTimeDuration return time in p.configs by key
 func (p *AdapterConfig) GetTimeDuration(key string, defValue ...time.Duration) time.Duration {
+	if d, err := convertDuration(p.GetInterface(key)); err == nil {
+		return d
+	}
 	return formats.ParseStringTime(strings.ToLower(p.GetString(key)), defValue...)
 }
 
+// GetTime return time in p.configs by key, supported time.Time values
+// and strings in RFC3339, datetime, date or time layouts
+func (p *AdapterConfig) GetTime(key string, defValue ...time.Time) (res time.Time) {
+	t, err := convertTime(p.GetInterface(key))
+	if err != nil && len(defValue) > 0 {
+		return defValue[0]
+	}
+	return t
+}
+
 // GetByteSize return time in p.configs by key
 func (p *AdapterConfig) GetByteSize(key string, defValue ...*big.Int) *big.Int {
 	return formats.ParseStringByteSize(strings.ToLower(p.GetString(key)), defValue...)
@@ -172,7 +261,7 @@ func (p *AdapterConfig) GetInterface(key string, defValue ...interface{}) (res i
 		return ErrInvalidKey
 	}
 
-	v, err = p.getKeyValue(key)
+	v, err = p.GetKeyValue(key)
 	return
 }
 
@@ -208,13 +297,8 @@ func (p *AdapterConfig) GetBoolean(key string, defValue ...bool) (b bool) {
 		return
 	}
 
-	switch reflect.TypeOf(v).Kind() {
-	case reflect.Bool:
-		ok, b = true, v.(bool)
-	case reflect.String:
-		ok, b = true, strings.ToLower(v.(string)) == "on"
-	}
-
+	b, err := convertBool(v)
+	ok = err == nil
 	return
 }
 
@@ -336,7 +420,7 @@ func (p *AdapterConfig) GetFloatList(key string) []float64 {
 // GetMap get map value
 func (p *AdapterConfig) GetMap(key string) Options {
 
-	vm, err := p.getKeyValue(key)
+	vm, err := p.GetKeyValue(key)
 	if err != nil {
 		return nil
 	}
@@ -364,7 +448,7 @@ func (p *AdapterConfig) GetMap(key string) Options {
 // GetConfig return object config in p.configs by key
 func (p *AdapterConfig) GetConfig(key string) Config {
 
-	vm, err := p.getKeyValue(key)
+	vm, err := p.GetKeyValue(key)
 	if err != nil {
 		return nil
 	}
@@ -377,28 +461,40 @@ func (p *AdapterConfig) GetConfig(key string) Config {
 	return c
 }
 
-// ToObject unmarshal values to object
+// ToObject bind values to object, which should be a non-nil pointer,
+// fields are matched by tags: config, yaml, json, toml, or their names case-insensitively,
+// in strict mode, it fails if values have keys which are not mapped to any field
 func (p *AdapterConfig) ToObject(key string, model interface{}) (err error) {
+	rv := reflect.ValueOf(model)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrNotPointer
+	}
 
 	var vm interface{}
 	if key != "" {
-		vm, err = p.getKeyValue(key)
+		vm, err = p.GetKeyValue(key)
 		if err != nil {
 			return
 		}
+		vm = DeepCopy(vm)
 	} else {
 		vm = p.copy().configs
 	}
 
-	switch p.readerType {
-	case ReaderTypeJSON:
-		bs, _ := json.Marshal(vm)
-		err = json.Unmarshal(bs, model)
-	case ReaderTypeYAML:
-		bs, _ := yaml.Marshal(vm)
-		err = yaml.Unmarshal(bs, model)
+	if p.strict {
+		ue := &UnknownKeysError{}
+		structUnknownKeys(ue, key, vm, rv.Type())
+		if err = ue.orNil(); err != nil {
+			return
+		}
 	}
-	return err
+
+	if err = decodeValue(key, vm, rv.Elem()); err != nil {
+		p.locker.RLock()
+		defer p.locker.RUnlock()
+		return annotateError(p.layers, p.runtime, err)
+	}
+	return nil
 }
 
 // GetValuesConfig get key's values if values can be Config, or panic
@@ -422,16 +518,68 @@ func (p *AdapterConfig) SetKeyValue(key string, value interface{}) (err error) {
 	if len(key) == 0 {
 		return ErrInvalidKey
 	}
+
+	var changes []KeyChange
+	defer func() {
+		if err == nil {
+			p.notify(changes)
+		}
+	}()
+
 	p.locker.Lock()
 	defer p.locker.Unlock()
-	return p.setKeyValue(key, value)
+
+	var oldConfigs map[string]interface{}
+	if p.hasSubscribers() {
+		oldConfigs = flattenConfigs(p.configs)
+	}
+
+	if p.schema != nil {
+		configs := DeepCopy(p.configs).(map[string]interface{})
+		if err = setMapKeyValue(configs, key, value); err != nil {
+			return
+		}
+		if err = p.schema.validate(configs); err != nil {
+			return annotateError(p.layers, p.runtime, err)
+		}
+	}
+
+	if err = p.setKeyValue(key, value); err != nil {
+		return
+	}
+	p.runtime = appendKeyValue(p.runtime, key, value)
+
+	if oldConfigs != nil {
+		changes = diffFlattened(oldConfigs, flattenConfigs(p.configs))
+	}
+	return
 }
 
-// Dump return p.configs' bytes
+// LayerOf return the name of the layer which supplies the key's value
+func (p *AdapterConfig) LayerOf(key string) string {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+	return layerOf(p.layers, p.runtime, key)
+}
+
+// Dump return p.configs' bytes, if the primary layer is a json or yaml source,
+// the other layers' values are set into it, with its comments and formatting, and ${...} are kept
 func (p *AdapterConfig) Dump() (bs []byte, err error) {
 	p.locker.Lock()
 	defer p.locker.Unlock()
 
+	if primary := primaryLayer(p.layers); primary.editable() {
+		kvs, ok, err := layerEdits(p.layers, primary)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			bs, err = dumpLayer(primary, append(kvs, p.runtime...))
+			if !errors.Is(err, ErrIncludeNotEditable) {
+				return bs, err
+			}
+		}
+	}
 	return p.reader.Dump(p.configs)
 }
 
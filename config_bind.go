@@ -0,0 +1,202 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// tagNames the struct tags to find a field's key, by priority
+var tagNames = []string{"config", "yaml", "json", "toml"}
+
+// fieldTag return the key name and options of the field in its tags
+func fieldTag(f reflect.StructField) (string, []string) {
+	for _, t := range tagNames {
+		if tag, ok := f.Tag.Lookup(t); ok {
+			parts := strings.Split(tag, ",")
+			return parts[0], parts[1:]
+		}
+	}
+	return "", nil
+}
+
+func hasTagOption(opts []string, names ...string) bool {
+	for _, o := range opts {
+		for _, n := range names {
+			if o == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookupField find the field's value in m, by exact name first,
+// then case-insensitive in order of keys, so that the result doesn't depend on map's order
+func lookupField(m map[string]interface{}, name string) (string, interface{}, bool) {
+	if v, ok := m[name]; ok {
+		return name, v, true
+	}
+	for _, k := range sortedKeys(m) {
+		if strings.EqualFold(k, name) {
+			return k, m[k], true
+		}
+	}
+	return "", nil, false
+}
+
+// bindStruct set the struct's fields with the values of map v,
+// missing fields are set with their default tags
+func bindStruct(key string, v interface{}, rv reflect.Value) error {
+	var m map[string]interface{}
+	if v != nil {
+		var ok bool
+		if m, ok = toStringMap(v); !ok {
+			return keyError(key, v, rv.Type().String(), errTypeMismatch)
+		}
+	}
+
+	ve := &ValidationError{}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		name, opts := fieldTag(f)
+		if name == "-" {
+			continue
+		}
+		fv := rv.Field(i)
+
+		if (f.Anonymous && name == "") || hasTagOption(opts, "squash", "inline") {
+			if sv, ok := squashedStruct(fv); ok {
+				if err := collectViolations(ve, bindStruct(key, v, sv)); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		found, value, ok := lookupField(m, name)
+		if ok {
+			name = found
+		}
+		k := childKey(key, name)
+		_, hasDefault := f.Tag.Lookup("default")
+		present := ok && value != nil
+
+		var err error
+		switch {
+		case !present:
+			err = bindDefault(k, f, fv)
+		case hasTagOption(opts, "bytesize"):
+			err = decodeByteSize(k, value, fv)
+		default:
+			err = decodeValue(k, fieldList(value, fv), fv)
+		}
+		if err = collectViolations(ve, err); err != nil {
+			return err
+		}
+
+		ve.Violations = append(ve.Violations, validateValue(k, f.Tag.Get("validate"), fv, present || hasDefault)...)
+	}
+	return ve.orNil()
+}
+
+// fieldList return a single value of a slice field as a list with one item,
+// exp: a repeated xml element which appears once, Get[[]T] doesn't do this
+func fieldList(v interface{}, fv reflect.Value) interface{} {
+	if fv.Kind() != reflect.Slice || fv.Type().Elem().Kind() == reflect.Uint8 {
+		return v
+	}
+	if _, err := convertList(v); err != nil {
+		return []interface{}{v}
+	}
+	return v
+}
+
+// squashedStruct return the settable struct of an embedded field
+func squashedStruct(fv reflect.Value) (reflect.Value, bool) {
+	if fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct {
+		if fv.IsNil() {
+			if !fv.CanSet() {
+				return fv, false
+			}
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		fv = fv.Elem()
+	}
+	return fv, fv.Kind() == reflect.Struct
+}
+
+// bindDefault set the field with its default tag, or set the defaults of the nested struct
+func bindDefault(key string, f reflect.StructField, fv reflect.Value) error {
+	def, ok := f.Tag.Lookup("default")
+	if !ok {
+		if fv.Kind() == reflect.Struct && isPlainStruct(fv) {
+			return bindStruct(key, nil, fv)
+		}
+		return nil
+	}
+
+	if _, opts := fieldTag(f); hasTagOption(opts, "bytesize") {
+		return decodeByteSize(key, def, fv)
+	}
+
+	t := f.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() != reflect.Uint8 {
+		items := []interface{}{}
+		if def != "" {
+			for _, s := range strings.Split(def, ",") {
+				items = append(items, strings.TrimSpace(s))
+			}
+		}
+		return decodeValue(key, items, fv)
+	}
+	return decodeValue(key, def, fv)
+}
+
+// isPlainStruct judge if the struct is decoded field by field
+func isPlainStruct(rv reflect.Value) bool {
+	switch rv.Type() {
+	case typeTime, typeBigInt:
+		return false
+	}
+	return !(rv.CanAddr() && rv.Addr().Type().Implements(typeTextUnmarshaler))
+}
+
+// decodeByteSize decode byte size like 10mb into an integer
+func decodeByteSize(key string, v interface{}, rv reflect.Value) error {
+	b, err := convertByteSize(v)
+	if err != nil {
+		return keyError(key, v, "byte size", err)
+	}
+	if !b.IsInt64() {
+		return keyError(key, v, rv.Type().String(), errOutOfRange)
+	}
+	return decodeValue(key, b.Int64(), rv)
+}
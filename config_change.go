@@ -0,0 +1,235 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ChangeType define the type of a key's change
+type ChangeType int
+
+const (
+	// ChangeAdded the key is added
+	ChangeAdded ChangeType = iota + 1
+	// ChangeRemoved the key is removed
+	ChangeRemoved
+	// ChangeModified the key's value is modified
+	ChangeModified
+)
+
+// KeyChange a dotted key's change with old and new values
+type KeyChange struct {
+	Key      string
+	Type     ChangeType
+	OldValue interface{}
+	NewValue interface{}
+}
+
+// ChangeEvent changes of the keys under the subscribed prefix, sorted by key
+type ChangeEvent struct {
+	Prefix  string
+	Changes []KeyChange
+}
+
+// Added return the added keys' changes
+func (p ChangeEvent) Added() []KeyChange {
+	return p.filter(ChangeAdded)
+}
+
+// Removed return the removed keys' changes
+func (p ChangeEvent) Removed() []KeyChange {
+	return p.filter(ChangeRemoved)
+}
+
+// Modified return the modified keys' changes
+func (p ChangeEvent) Modified() []KeyChange {
+	return p.filter(ChangeModified)
+}
+
+func (p ChangeEvent) filter(t ChangeType) []KeyChange {
+	var changes []KeyChange
+	for _, c := range p.Changes {
+		if c.Type == t {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+type changeSubscriber struct {
+	prefix string
+	fn     func(ChangeEvent)
+}
+
+// OnChange register fn to receive the changes of keys under prefix,
+// which are made by SetKeyValue or Reload, empty prefix means all keys.
+// it returns a function to cancel the subscription
+func (p *AdapterConfig) OnChange(prefix string, fn func(ChangeEvent)) (cancel func()) {
+	p.subLocker.Lock()
+	defer p.subLocker.Unlock()
+
+	if p.subscribers == nil {
+		p.subscribers = make(map[int]*changeSubscriber)
+	}
+	p.subID++
+	id := p.subID
+	if prefix != "" {
+		prefix = canonicalKey(prefix)
+	}
+	p.subscribers[id] = &changeSubscriber{prefix: prefix, fn: fn}
+
+	return func() {
+		p.subLocker.Lock()
+		defer p.subLocker.Unlock()
+		delete(p.subscribers, id)
+	}
+}
+
+func (p *AdapterConfig) hasSubscribers() bool {
+	p.subLocker.Lock()
+	defer p.subLocker.Unlock()
+	return len(p.subscribers) > 0
+}
+
+// notify send changes to the subscribers whose prefix matches
+func (p *AdapterConfig) notify(changes []KeyChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	p.subLocker.Lock()
+	ids := make([]int, 0, len(p.subscribers))
+	for id := range p.subscribers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	subscribers := make([]*changeSubscriber, 0, len(ids))
+	for _, id := range ids {
+		subscribers = append(subscribers, p.subscribers[id])
+	}
+	p.subLocker.Unlock()
+
+	for _, s := range subscribers {
+		var matched []KeyChange
+		for _, c := range changes {
+			if keyMatchesPrefix(c.Key, s.prefix) {
+				matched = append(matched, c)
+			}
+		}
+		if len(matched) > 0 {
+			s.fn(ChangeEvent{Prefix: s.prefix, Changes: matched})
+		}
+	}
+}
+
+// keyMatchesPrefix judge if the key is under the prefix, or the key is the prefix's parent
+func keyMatchesPrefix(key, prefix string) bool {
+	if prefix == "" || key == prefix {
+		return true
+	}
+	return strings.HasPrefix(key, prefix+".") || strings.HasPrefix(prefix, key+".")
+}
+
+// diffConfigs return the changes of leaf keys from old configs to new configs
+func diffConfigs(oldConfigs, newConfigs map[string]interface{}) []KeyChange {
+	return diffFlattened(flattenConfigs(oldConfigs), flattenConfigs(newConfigs))
+}
+
+// diffFlattened return the changes from old leaf values to new leaf values
+func diffFlattened(oldValues, newValues map[string]interface{}) []KeyChange {
+	var changes []KeyChange
+	for k, ov := range oldValues {
+		nv, ok := newValues[k]
+		switch {
+		case !ok:
+			changes = append(changes, KeyChange{Key: k, Type: ChangeRemoved, OldValue: ov})
+		case !equalValues(ov, nv):
+			changes = append(changes, KeyChange{Key: k, Type: ChangeModified, OldValue: ov, NewValue: nv})
+		}
+	}
+	for k, nv := range newValues {
+		if _, ok := oldValues[k]; !ok {
+			changes = append(changes, KeyChange{Key: k, Type: ChangeAdded, NewValue: nv})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// equalValues judge if the leaf values are deeply equal, NaNs are equal to NaNs
+func equalValues(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	if fa, ok := a.(float64); ok {
+		fb, ok := b.(float64)
+		return ok && math.IsNaN(fa) && math.IsNaN(fb)
+	}
+
+	la, ok := a.([]interface{})
+	if !ok {
+		ma, ok := toStringMap(a)
+		if !ok {
+			return false
+		}
+		mb, ok := toStringMap(b)
+		if !ok || len(ma) != len(mb) {
+			return false
+		}
+		for k, v := range ma {
+			if w, ok := mb[k]; !ok || !equalValues(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	lb, ok := b.([]interface{})
+	if !ok || len(la) != len(lb) {
+		return false
+	}
+	for i := range la {
+		if !equalValues(la[i], lb[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// flattenConfigs return all leaf values by dotted keys, lists are leaves
+func flattenConfigs(configs map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{})
+	flattenInto(values, nil, configs)
+	return values
+}
+
+func flattenInto(values map[string]interface{}, tokens []string, configs map[string]interface{}) {
+	for k, v := range configs {
+		keys := append(append([]string{}, tokens...), k)
+		vm, ok := toStringMap(v)
+		if ok && len(vm) > 0 {
+			flattenInto(values, keys, vm)
+			continue
+		}
+		values[joinKey(keys)] = v
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LayerEnv name of the layer which holds values overridden by environment variables
+const LayerEnv = "env"
+
+// EnvOverride an environment variable which overrides the key's value
+type EnvOverride struct {
+	Name  string
+	Key   string
+	Value string
+}
+
+// envNormalize default normalizer of key's segments, exp: pool-size => POOL_SIZE
+func envNormalize(segment string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(segment))
+}
+
+// envOverlay finder of environment variables which override the configs' keys
+type envOverlay struct {
+	prefix    string
+	separator string
+	normalize func(string) string
+
+	overrides []EnvOverride
+	values    []keyValue
+}
+
+// envName return the variable's name of the key's tokens, exp: APP_DB_POOL_SIZE
+func (p *envOverlay) envName(tokens []string) string {
+	names := make([]string, 0, len(tokens)+1)
+	if prefix := strings.TrimSuffix(p.prefix, p.separator); prefix != "" {
+		names = append(names, prefix)
+	}
+	for _, t := range tokens {
+		names = append(names, p.normalize(t))
+	}
+	return strings.Join(names, p.separator)
+}
+
+// walk find the variables of v's keys
+func (p *envOverlay) walk(tokens []string, v interface{}) error {
+	if m, ok := toStringMap(v); ok && len(m) > 0 {
+		for _, k := range sortedKeys(m) {
+			if err := p.walk(append(append([]string{}, tokens...), k), m[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if l, ok := v.([]interface{}); ok {
+		// comma separated values replace the list
+		if s, ok := os.LookupEnv(p.envName(tokens)); ok {
+			list, err := coerceString(joinKey(tokens), s, l)
+			if err != nil {
+				return err
+			}
+			p.add(tokens, s, list)
+			return nil
+		}
+
+		var template interface{}
+		if len(l) > 0 {
+			template = l[0]
+		}
+
+		// indexed values override the items, and append items after the last one
+		for i := 0; ; i++ {
+			item := template
+			if i < len(l) {
+				item = l[i]
+			}
+			n := len(p.overrides)
+			if err := p.walk(append(append([]string{}, tokens...), strconv.Itoa(i)), item); err != nil {
+				return err
+			}
+			if i >= len(l) && n == len(p.overrides) {
+				return nil
+			}
+		}
+	}
+
+	s, ok := os.LookupEnv(p.envName(tokens))
+	if !ok {
+		return nil
+	}
+	value, err := coerceString(joinKey(tokens), s, v)
+	if err != nil {
+		return err
+	}
+	p.add(tokens, s, value)
+	return nil
+}
+
+func (p *envOverlay) add(tokens []string, s string, value interface{}) {
+	key := joinKey(tokens)
+	p.overrides = append(p.overrides, EnvOverride{Name: p.envName(tokens), Key: key, Value: s})
+	p.values = append(p.values, keyValue{key: key, value: value})
+}
+
+// coerceString convert the string into the type of the current value,
+// lists are comma separated, and their items are converted into the type of the first item
+func coerceString(key, s string, current interface{}) (interface{}, error) {
+	var v interface{}
+	var err error
+	switch t := current.(type) {
+	case []interface{}:
+		var template interface{}
+		if len(t) > 0 {
+			template = t[0]
+		}
+		list := []interface{}{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			value, err := coerceString(key, item, template)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case bool:
+		v, err = strconv.ParseBool(s)
+	case int:
+		v, err = strconv.Atoi(s)
+	case int64:
+		v, err = strconv.ParseInt(s, 10, 64)
+	case uint64:
+		v, err = strconv.ParseUint(s, 10, 64)
+	case float64:
+		v, err = strconv.ParseFloat(s, 64)
+	case json.Number:
+		if _, err = strconv.ParseFloat(s, 64); err == nil {
+			v = json.Number(s)
+		}
+	case time.Duration:
+		v, err = convertDuration(s)
+	case time.Time:
+		v, err = parseTimeString(s)
+	default:
+		return s, nil
+	}
+	if err != nil {
+		return nil, &KeyParseError{Key: key, Value: s, Err: err}
+	}
+	return v, nil
+}
+
+// appendEnvLayer append the layer of environment variables which override the layers' keys,
+// if the overlay is enabled
+func (p *AdapterConfig) appendEnvLayer(layers []*configLayer) ([]*configLayer, error) {
+	if !p.envOverlay {
+		return layers, nil
+	}
+
+	configs, err := mergeLayers(layers, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &envOverlay{prefix: p.EnvPrefix, separator: p.envSeparator, normalize: p.envNormalizer}
+	if o.separator == "" {
+		o.separator = "_"
+	}
+	if o.normalize == nil {
+		o.normalize = envNormalize
+	}
+	if err = o.walk(nil, configs); err != nil {
+		return nil, err
+	}
+
+	l, err := overlayLayer(LayerEnv, configs, o.values)
+	if err != nil {
+		return nil, err
+	}
+	l.overrides = append([]EnvOverride{}, o.overrides...)
+	return append(layers, l), nil
+}
+
+// EnvOverrides return the environment variables which override keys' values
+func (p *AdapterConfig) EnvOverrides() []EnvOverride {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
+	for _, l := range p.layers {
+		if l.generated && l.name == LayerEnv {
+			return append([]EnvOverride{}, l.overrides...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// LayerFlags name of the layer which holds values set by command-line flags
+const LayerFlags = "flags"
+
+// appendFlagLayer append the layer of the flags which are set explicitly, if a flag set is bound,
+// flags are named by keys, exp: --db.pool.size=20, other flags of the application are skipped
+func (p *AdapterConfig) appendFlagLayer(layers []*configLayer) ([]*configLayer, error) {
+	if p.flagSet == nil {
+		return layers, nil
+	}
+
+	configs, err := mergeLayers(layers, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var kvs []keyValue
+	p.flagSet.Visit(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		// only flags defined by DefineFlags, or named by the keys in configs, exp: not --verbose
+		current, exists, lookupErr := lookupMapKeyValue(configs, f.Name)
+		if _, defined := f.Value.(*flagValue); !defined && (lookupErr != nil || !exists) {
+			return
+		}
+
+		var v interface{} = f.Value.String()
+		if g, ok := f.Value.(flag.Getter); ok {
+			v = g.Get()
+		}
+		if s, ok := v.(string); ok {
+			v, err = coerceString(f.Name, s, current)
+		}
+		kvs = append(kvs, keyValue{key: canonicalKey(f.Name), value: v})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := overlayLayer(LayerFlags, configs, kvs)
+	if err != nil {
+		return nil, err
+	}
+	return append(layers, l), nil
+}
+
+// DefineFlags define the flags of the target struct's keys into fs, which are not defined,
+// the usage is the field's usage tag, the default is the key's value in c or the field's default tag
+func DefineFlags(fs *flag.FlagSet, c Config, target interface{}) error {
+	t := reflect.TypeOf(target)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+	defineStructFlags(fs, c, "", t)
+	return nil
+}
+
+func defineStructFlags(fs *flag.FlagSet, c Config, key string, t reflect.Type) {
+	for _, f := range structFields(t) {
+		k := childKey(key, f.name)
+		ft := f.typ
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && ft != typeTime && ft != typeBigInt &&
+			!reflect.PtrTo(ft).Implements(typeTextUnmarshaler) {
+			defineStructFlags(fs, c, k, ft)
+			continue
+		}
+		if ft.Kind() == reflect.Map {
+			continue
+		}
+		def, _ := f.tag.Lookup("default")
+		defineFlag(fs, c, k, flagTemplate(ft), f.tag.Get("usage"), def)
+	}
+}
+
+// DefineSchemaFlags define the flags of the schema's properties into fs, which are not defined,
+// the usage is the property's description, the default is the key's value in c or the property's default
+func DefineSchemaFlags(fs *flag.FlagSet, c Config, schema []byte) error {
+	s, err := parseSchema(schema)
+	if err != nil {
+		return err
+	}
+	s.defineFlags(fs, c, "", s.root, 0)
+	return nil
+}
+
+func (p *jsonSchema) defineFlags(fs *flag.FlagSet, c Config, key string, node interface{}, refs int) {
+	s, ok := node.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if ref, ok := s["$ref"].(string); ok && refs < maxSchemaRefs {
+		if target, err := p.resolveRef(ref); err == nil {
+			p.defineFlags(fs, c, key, target, refs+1)
+		}
+		return
+	}
+
+	if properties, ok := s["properties"].(map[string]interface{}); ok {
+		for _, k := range sortedKeys(properties) {
+			p.defineFlags(fs, c, childKey(key, k), properties[k], 0)
+		}
+		return
+	}
+	if key == "" {
+		return
+	}
+
+	template := p.flagTemplate(s, 0)
+	if template == nil {
+		return
+	}
+	usage, _ := s["description"].(string)
+	def := ""
+	if v, ok := s["default"]; ok {
+		def = flagString(v)
+	}
+	defineFlag(fs, c, key, template, usage, def)
+}
+
+// flagTemplate return the zero value of the schema's type, nil if it's an object
+func (p *jsonSchema) flagTemplate(s map[string]interface{}, refs int) interface{} {
+	if ref, ok := s["$ref"].(string); ok && refs < maxSchemaRefs {
+		if target, err := p.resolveRef(ref); err == nil {
+			if ts, ok := target.(map[string]interface{}); ok {
+				return p.flagTemplate(ts, refs+1)
+			}
+		}
+		return ""
+	}
+
+	typ, _ := s["type"].(string)
+	if types, ok := s["type"].([]interface{}); ok {
+		for _, t := range types {
+			if t, ok := t.(string); ok && t != "null" {
+				typ = t
+				break
+			}
+		}
+	}
+	switch typ {
+	case "boolean":
+		return false
+	case "integer":
+		return int64(0)
+	case "number":
+		return float64(0)
+	case "object":
+		return nil
+	case "array":
+		items, _ := s["items"].(map[string]interface{})
+		item := p.flagTemplate(items, refs)
+		if item == nil {
+			return nil
+		}
+		return []interface{}{item}
+	}
+	return ""
+}
+
+// defineFlag define the flag of key, if it's not defined
+func defineFlag(fs *flag.FlagSet, c Config, key string, template interface{}, usage, def string) {
+	if fs.Lookup(key) != nil {
+		return
+	}
+	if c != nil {
+		if v, err := getInterfaceE(c, key); err == nil {
+			def = flagString(v)
+		}
+	}
+	fs.Var(&flagValue{key: key, template: template, value: def}, key, usage)
+}
+
+// flagTemplate return the zero value of the type, which the flag's value is converted into
+func flagTemplate(t reflect.Type) interface{} {
+	switch t {
+	case typeDuration:
+		return time.Duration(0)
+	case typeTime:
+		return time.Time{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return false
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return 0
+	case reflect.Int64:
+		return int64(0)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return uint64(0)
+	case reflect.Float32, reflect.Float64:
+		return float64(0)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() != reflect.Uint8 {
+			return []interface{}{flagTemplate(t.Elem())}
+		}
+	}
+	return ""
+}
+
+// flagString format the value as a flag's value, lists are comma separated
+func flagString(v interface{}) string {
+	if l, ok := v.([]interface{}); ok {
+		items := make([]string, 0, len(l))
+		for _, item := range l {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// flagValue value of the flags defined by DefineFlags, converted into the type of template
+type flagValue struct {
+	key      string
+	template interface{}
+	value    string
+}
+
+func (p *flagValue) String() string {
+	return p.value
+}
+
+// Set check and set the flag's value
+func (p *flagValue) Set(s string) error {
+	if _, err := coerceString(p.key, s, p.template); err != nil {
+		return err
+	}
+	p.value = s
+	return nil
+}
+
+// Get return the flag's value in the type of template
+func (p *flagValue) Get() interface{} {
+	v, _ := coerceString(p.key, p.value, p.template)
+	return v
+}
+
+// IsBoolFlag judge if the flag can be set without a value, exp: --debug
+func (p *flagValue) IsBoolFlag() bool {
+	_, ok := p.template.(bool)
+	return ok
+}
@@ -0,0 +1,246 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iTrellis/common/errors"
+)
+
+// IncludeKey the key of include directives, exp: {"$include": "db.json"},
+// yaml's "!include db.yml" is parsed into it
+const IncludeKey = "$include"
+
+// includeResolver resolver of include directives in a layer
+type includeResolver struct {
+	opts      []ReaderOptionFunc
+	files     []string
+	positions map[string]Position
+}
+
+// resolveIncludes replace the include directives in the layer's configs with the included files' configs
+func (p *configLayer) resolveIncludes(opts []ReaderOptionFunc) error {
+	r := &includeResolver{opts: opts, positions: p.positions}
+	if r.positions == nil {
+		r.positions = make(map[string]Position)
+	}
+
+	var chain []string
+	baseDir := ""
+	if len(p.file) > 0 {
+		chain = []string{absPath(p.file)}
+		baseDir = filepath.Dir(p.file)
+	}
+
+	v, err := r.resolve(p.configs, "", baseDir, chain)
+	if err != nil {
+		return err
+	}
+	configs, ok := toStringMap(v)
+	if !ok {
+		return &IncludeError{Chain: chain, Err: ErrNotMap}
+	}
+
+	p.configs, p.includes = configs, r.files
+	if len(r.positions) > 0 {
+		p.positions = r.positions
+	}
+	return nil
+}
+
+func (p *includeResolver) resolve(v interface{}, key, baseDir string, chain []string) (interface{}, error) {
+	if l, ok := v.([]interface{}); ok {
+		for i, item := range l {
+			child, err := p.resolve(item, childKey(key, strconv.Itoa(i)), baseDir, chain)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = child
+		}
+		return l, nil
+	}
+
+	m, ok := toStringMap(v)
+	if !ok {
+		return v, nil
+	}
+	for _, k := range sortedKeys(m) {
+		if k == IncludeKey {
+			continue
+		}
+		child, err := p.resolve(m[k], childKey(key, k), baseDir, chain)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = child
+	}
+
+	include, ok := m[IncludeKey]
+	if !ok {
+		return m, nil
+	}
+	delete(m, IncludeKey)
+	delete(p.positions, childKey(key, IncludeKey))
+
+	patterns, err := includePatterns(include)
+	if err != nil {
+		return nil, &IncludeError{Chain: chain, Err: err}
+	}
+
+	var values []interface{}
+	for _, pattern := range patterns {
+		files, err := includeFiles(baseDir, pattern)
+		if err != nil {
+			return nil, &IncludeError{Chain: chain, Err: err}
+		}
+		for _, f := range files {
+			included, err := p.load(f, key, chain)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, included)
+		}
+	}
+
+	// a single included value which isn't a map replaces the directive
+	if len(values) == 1 && len(m) == 0 {
+		if _, isMap := toStringMap(values[0]); !isMap {
+			return values[0], nil
+		}
+	}
+
+	result := make(map[string]interface{})
+	for _, included := range values {
+		im, ok := toStringMap(included)
+		if !ok {
+			return nil, &IncludeError{Chain: chain, Err: errors.Newf("included value at %q is not a map", key)}
+		}
+		deepMerge(result, im)
+	}
+	deepMerge(result, m)
+	return result, nil
+}
+
+// load parse the included file, and resolve its includes
+func (p *includeResolver) load(file, key string, chain []string) (interface{}, error) {
+	abs := absPath(file)
+	chain = append(append([]string{}, chain...), abs)
+	for _, c := range chain[:len(chain)-1] {
+		if c == abs {
+			return nil, &IncludeError{Chain: chain, Err: ErrIncludeCycle}
+		}
+	}
+
+	data, err := readFile(file)
+	if err != nil {
+		return nil, &IncludeError{Chain: chain, Err: err}
+	}
+	reader, err := newConfigReader(fileToReaderType(file), file, p.opts)
+	if err != nil {
+		return nil, &IncludeError{Chain: chain, Err: err}
+	}
+
+	var v interface{}
+	var positions map[string]Position
+	if pr, ok := reader.(PositionReader); ok {
+		positions, err = pr.ParseDataPositions(data, &v)
+	} else {
+		err = reader.ParseData(data, &v)
+	}
+	if err != nil {
+		return nil, &IncludeError{Chain: chain, Err: err}
+	}
+	p.files = append(p.files, file)
+
+	// positions of the included keys, the including file's keys have higher precedence
+	for k, pos := range positions {
+		k = childKeyPath(key, k)
+		if _, ok := p.positions[k]; !ok {
+			p.positions[k] = pos
+		}
+	}
+
+	inner := &includeResolver{opts: p.opts, positions: make(map[string]Position)}
+	v, err = inner.resolve(v, "", filepath.Dir(file), chain)
+	if err != nil {
+		return nil, err
+	}
+	p.files = append(p.files, inner.files...)
+	for k, pos := range inner.positions {
+		k = childKeyPath(key, k)
+		if _, ok := p.positions[k]; !ok {
+			p.positions[k] = pos
+		}
+	}
+	return v, nil
+}
+
+// childKeyPath join the parent key and the dotted child key
+func childKeyPath(parent, child string) string {
+	if parent == "" {
+		return child
+	}
+	return parent + "." + child
+}
+
+// includePatterns return the paths of include directive, a string or a list of strings
+func includePatterns(v interface{}) ([]string, error) {
+	switch t := v.(type) {
+	case string:
+		return []string{t}, nil
+	case []interface{}:
+		patterns := make([]string, 0, len(t))
+		for _, item := range t {
+			s, ok := item.(string)
+			if !ok {
+				return nil, errors.Newf("invalid include path: %v", item)
+			}
+			patterns = append(patterns, s)
+		}
+		return patterns, nil
+	}
+	return nil, errors.Newf("invalid include path: %v", v)
+}
+
+// includeFiles return the files of the pattern relative to baseDir,
+// patterns with globs match files in lexical order
+func includeFiles(baseDir, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(baseDir, pattern)
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func absPath(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return name
+}
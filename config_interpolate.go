@@ -0,0 +1,362 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/iTrellis/common/errors"
+)
+
+// reference operators
+const (
+	refOpDefault  = ":-"
+	refOpRequired = ":?"
+)
+
+// interpSegment a part of value: literal text or a ${...} reference
+type interpSegment struct {
+	literal string
+	ref     *interpRef
+}
+
+// interpRef reference like ${key}, ${key:-default} or ${key:?message}
+type interpRef struct {
+	key string
+	op  string
+	arg []interpSegment
+}
+
+// parseInterpolation split value into literal texts and references,
+// $${ is an escaped literal ${
+func parseInterpolation(s string) (segs []interpSegment, hasRef bool, err error) {
+	var literal strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "$${"):
+			literal.WriteString("${")
+			i += 3
+		case strings.HasPrefix(s[i:], "${"):
+			end := matchBrace(s, i+2)
+			if end < 0 {
+				return nil, false, ErrUnclosedReference
+			}
+			ref, err := parseReference(s[i+2 : end])
+			if err != nil {
+				return nil, false, err
+			}
+			if literal.Len() > 0 {
+				segs = append(segs, interpSegment{literal: literal.String()})
+				literal.Reset()
+			}
+			segs = append(segs, interpSegment{ref: ref})
+			hasRef = true
+			i = end + 1
+		default:
+			literal.WriteByte(s[i])
+			i++
+		}
+	}
+	if literal.Len() > 0 {
+		segs = append(segs, interpSegment{literal: literal.String()})
+	}
+	return
+}
+
+// matchBrace return the index of '}' which closes the reference started before start
+func matchBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "${"):
+			depth++
+			i++
+		case s[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseReference parse the body of ${...}
+func parseReference(body string) (*interpRef, error) {
+	ref := &interpRef{key: body}
+	for i := 0; i < len(body); i++ {
+		if strings.HasPrefix(body[i:], "${") {
+			// operators in nested references belong to them
+			end := matchBrace(body, i+2)
+			if end < 0 {
+				return nil, ErrUnclosedReference
+			}
+			i = end
+			continue
+		}
+		if strings.HasPrefix(body[i:], refOpDefault) || strings.HasPrefix(body[i:], refOpRequired) {
+			ref.key, ref.op = body[:i], body[i:i+2]
+			arg, _, err := parseInterpolation(body[i+2:])
+			if err != nil {
+				return nil, err
+			}
+			ref.arg = arg
+			break
+		}
+	}
+	ref.key = strings.TrimSpace(ref.key)
+	if ref.key == "" {
+		return nil, ErrInvalidKey
+	}
+	return ref, nil
+}
+
+// interpNode a value which contains references
+type interpNode struct {
+	key   string
+	value string
+	segs  []interpSegment
+	refs  []string
+	set   func(interface{})
+}
+
+// copyDollarSymbol replace values like ${X.Y.Z} with X.Y.Z's value in configs,
+// values are resolved after the values they refer to, cycles are reported
+func (p *AdapterConfig) copyDollarSymbol(configs map[string]interface{}) error {
+	var nodes []*interpNode
+	if err := collectInterpNodes(&nodes, nil, configs); err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	ordered, err := p.sortInterpNodes(nodes)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range ordered {
+		v, err := p.evalSegments(configs, n.segs)
+		if err != nil {
+			return &InterpolationError{Key: n.key, Value: n.value, Err: err}
+		}
+		n.set(v)
+	}
+	return nil
+}
+
+// collectInterpNodes collect the string values which contain references or escapes
+func collectInterpNodes(nodes *[]*interpNode, tokens []string, v interface{}) error {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, item := range t {
+			m, k := t, k
+			if err := collectInterpItem(nodes, append(tokens, k), item, func(nv interface{}) { m[k] = nv }); err != nil {
+				return err
+			}
+		}
+	case Options:
+		for k, item := range t {
+			m, k := t, k
+			if err := collectInterpItem(nodes, append(tokens, k), item, func(nv interface{}) { m[k] = nv }); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, item := range t {
+			l, i := t, i
+			if err := collectInterpItem(nodes, append(tokens, fmt.Sprint(i)), item, func(nv interface{}) { l[i] = nv }); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func collectInterpItem(nodes *[]*interpNode, tokens []string, v interface{}, set func(interface{})) error {
+	s, ok := v.(string)
+	if !ok {
+		return collectInterpNodes(nodes, tokens, v)
+	}
+	if !strings.Contains(s, "${") {
+		return nil
+	}
+
+	key := joinKey(tokens)
+	segs, _, err := parseInterpolation(s)
+	if err != nil {
+		return &InterpolationError{Key: key, Value: s, Err: err}
+	}
+	*nodes = append(*nodes, &interpNode{key: key, value: s, segs: segs, refs: segmentRefs(segs), set: set})
+	return nil
+}
+
+// segmentRefs return all keys referred by segments, including the ones in defaults
+func segmentRefs(segs []interpSegment) []string {
+	var refs []string
+	for _, seg := range segs {
+		if seg.ref == nil {
+			continue
+		}
+		refs = append(refs, seg.ref.key)
+		refs = append(refs, segmentRefs(seg.ref.arg)...)
+	}
+	return refs
+}
+
+// sortInterpNodes sort nodes by dependencies, the referred ones first
+func (p *AdapterConfig) sortInterpNodes(nodes []*interpNode) ([]*interpNode, error) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].key < nodes[j].key })
+
+	deps := make(map[*interpNode][]*interpNode, len(nodes))
+	for _, n := range nodes {
+		for _, ref := range n.refs {
+			if _, _, ok := lookupResolver(ref); ok || p.envReference(ref) {
+				continue
+			}
+			ref = canonicalKey(ref)
+			for _, d := range nodes {
+				if d.key == ref || strings.HasPrefix(d.key, ref+".") || strings.HasPrefix(ref, d.key+".") {
+					deps[n] = append(deps[n], d)
+				}
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := make(map[*interpNode]int, len(nodes))
+	var stack []*interpNode
+	ordered := make([]*interpNode, 0, len(nodes))
+
+	var visit func(n *interpNode) error
+	visit = func(n *interpNode) error {
+		switch states[n] {
+		case visited:
+			return nil
+		case visiting:
+			var path []string
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] == n {
+					for _, s := range stack[i:] {
+						path = append(path, s.key)
+					}
+					break
+				}
+			}
+			return &ReferenceCycleError{Path: append(path, n.key)}
+		}
+
+		states[n] = visiting
+		stack = append(stack, n)
+		for _, d := range deps[n] {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		states[n] = visited
+		ordered = append(ordered, n)
+		return nil
+	}
+
+	for _, n := range nodes {
+		if err := visit(n); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// envReference judge if the reference is resolved by environment
+func (p *AdapterConfig) envReference(key string) bool {
+	return p.envValue(key) != ""
+}
+
+// envValue return environment value of key if allowed, or the value in .env files
+func (p *AdapterConfig) envValue(key string) string {
+	if p.EnvAllowed && (p.EnvPrefix == "" || strings.HasPrefix(key, p.EnvPrefix)) {
+		if v, ok := os.LookupEnv(key); ok {
+			return v
+		}
+		return p.dotEnv[key]
+	}
+	return ""
+}
+
+// evalSegments return the value of segments, a single reference keeps the referred value's type
+func (p *AdapterConfig) evalSegments(configs map[string]interface{}, segs []interpSegment) (interface{}, error) {
+	if len(segs) == 1 && segs[0].ref != nil {
+		return p.resolveRef(configs, segs[0].ref)
+	}
+
+	var sb strings.Builder
+	for _, seg := range segs {
+		if seg.ref == nil {
+			sb.WriteString(seg.literal)
+			continue
+		}
+		v, err := p.resolveRef(configs, seg.ref)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			sb.WriteString(fmt.Sprint(v))
+		}
+	}
+	return sb.String(), nil
+}
+
+// resolveRef return the value of reference, from registered resolvers, from environment if allowed, or from configs
+func (p *AdapterConfig) resolveRef(configs map[string]interface{}, ref *interpRef) (v interface{}, err error) {
+	if fn, arg, ok := lookupResolver(ref.key); ok {
+		if v, err = fn(arg); err != nil {
+			return nil, err
+		}
+	} else if env := p.envValue(ref.key); env != "" {
+		return env, nil
+	} else if v, err = getMapKeyValue(configs, ref.key); err != nil && ref.op == "" {
+		return nil, err
+	}
+
+	if v != nil && v != "" {
+		return v, nil
+	}
+
+	switch ref.op {
+	case refOpDefault:
+		return p.evalSegments(configs, ref.arg)
+	case refOpRequired:
+		msg, err := p.evalSegments(configs, ref.arg)
+		if err != nil {
+			return nil, err
+		}
+		if s := fmt.Sprint(msg); s != "" {
+			return nil, errors.Newf("%s: %s", ref.key, s)
+		}
+		return nil, errors.Newf("%s: required value is not set", ref.key)
+	}
+	return v, nil
+}
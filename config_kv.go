@@ -18,115 +18,348 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package config
 
 import (
-	"os"
 	"reflect"
+	"strconv"
 	"strings"
-
-	"github.com/iTrellis/common/formats"
 )
 
-func (p *AdapterConfig) copyDollarSymbol(key string, maps *map[string]interface{}) error {
-	tokens := []string{}
-	if key != "" {
-		tokens = append(tokens, key)
+func (p *AdapterConfig) getKeyValue(key string) (interface{}, error) {
+	return getMapKeyValue(p.configs, key)
+}
+
+// setKeyValue set key value into *configs
+func (p *AdapterConfig) setKeyValue(key string, value interface{}) (err error) {
+	return setMapKeyValue(p.configs, key, value)
+}
+
+// getMapKeyValue get value with key from configs
+func getMapKeyValue(configs map[string]interface{}, key string) (interface{}, error) {
+	vm, _, err := lookupMapKeyValue(configs, key)
+	return vm, err
+}
+
+// lookupMapKeyValue get value with key from configs, and report whether the key exists
+func lookupMapKeyValue(configs map[string]interface{}, key string) (interface{}, bool, error) {
+	segs, err := parseKeyPath(key)
+	if err != nil {
+		return nil, false, err
 	}
-	for k, v := range *maps {
-		if v == nil {
-			return nil
+
+	var vm interface{} = configs
+	ok := true
+	for _, seg := range segs {
+		if vm, ok, err = childValue(vm, seg); err != nil {
+			return nil, false, err
 		}
-		keys := append(tokens, k)
-		switch reflect.TypeOf(v).Kind() {
-		case reflect.Map:
-			{
-				vm, ok := v.(map[string]interface{})
-				if !ok {
-					continue
-				}
-				err := p.copyDollarSymbol(strings.Join(keys, "."), &vm)
-				if err != nil {
-					return err
-				}
+	}
+	return vm, ok, nil
+}
+
+// childValue return the value of map's key or list's index
+func childValue(v interface{}, seg keySegment) (interface{}, bool, error) {
+	switch t := v.(type) {
+	case Options:
+		vm, ok := t[seg.name]
+		return vm, ok, nil
+	case map[string]interface{}:
+		vm, ok := t[seg.name]
+		return vm, ok, nil
+	case map[interface{}]interface{}:
+		vm, ok := t[seg.name]
+		return vm, ok, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if !isList(rv) {
+		return nil, false, ErrNotMap
+	}
+	i, err := listIndex(seg, rv.Len())
+	if err != nil {
+		return nil, false, err
+	}
+	if i < 0 || i >= rv.Len() {
+		return nil, false, nil
+	}
+	return rv.Index(i).Interface(), true, nil
+}
+
+// setMapKeyValue set key value into configs, the missing parents are created
+func setMapKeyValue(configs map[string]interface{}, key string, value interface{}) (err error) {
+	segs, err := parseKeyPath(key)
+	if err != nil {
+		return err
+	}
+	_, err = setChildValue(configs, segs, value)
+	return
+}
+
+// setChildValue set value into container by the segments, return the new container
+func setChildValue(container interface{}, segs []keySegment, value interface{}) (interface{}, error) {
+	if len(segs) == 0 {
+		return value, nil
+	}
+	seg := segs[0]
+
+	switch t := container.(type) {
+	case Options:
+		child, err := setChildValue(t[seg.name], segs[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		t[seg.name] = child
+		return t, nil
+	case map[string]interface{}:
+		child, err := setChildValue(t[seg.name], segs[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		t[seg.name] = child
+		return t, nil
+	case map[interface{}]interface{}:
+		child, err := setChildValue(t[seg.name], segs[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		t[seg.name] = child
+		return t, nil
+	}
+
+	rv := reflect.ValueOf(container)
+	if !isList(rv) {
+		// replace scalar values with a new list or map
+		if seg.index {
+			if i, err := listIndex(seg, 0); err != nil || i != 0 {
+				return nil, ErrIndexOutOfRange
 			}
-		case reflect.String:
-			{
-				s, ok := v.(string)
-				if !ok {
-					continue
-				}
-				_, matched := formats.FindStringSubmatchMap(s, includeReg)
-				if !matched {
-					continue
-				}
+			child, err := setChildValue(nil, segs[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{child}, nil
+		}
+		child, err := setChildValue(nil, segs[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{seg.name: child}, nil
+	}
 
-				pkey := s[2 : len(s)-1]
-				if p.EnvAllowed && (p.EnvPrefix == "" || strings.HasPrefix(pkey, p.EnvPrefix)) {
-					if env := os.Getenv(pkey); env != "" {
-						if err := p.setKeyValue(strings.Join(keys, "."), env); err != nil {
-							return err
-						}
-						continue
-					}
-				}
+	i, err := listIndex(seg, rv.Len())
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 || i > rv.Len() {
+		return nil, ErrIndexOutOfRange
+	}
+
+	list, ok := container.([]interface{})
+	if !ok {
+		list = make([]interface{}, rv.Len())
+		for j := range list {
+			list[j] = rv.Index(j).Interface()
+		}
+	}
+
+	if i == len(list) {
+		child, err := setChildValue(nil, segs[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return append(list, child), nil
+	}
 
-				vm, err := p.getKeyValue(pkey)
+	child, err := setChildValue(list[i], segs[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	list[i] = child
+	return list, nil
+}
+
+func isList(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		return rv.Type().Elem().Kind() != reflect.Uint8
+	}
+	return false
+}
+
+// listIndex return the index of list by segment, negative index counts from the end
+func listIndex(seg keySegment, length int) (int, error) {
+	i, err := strconv.Atoi(seg.name)
+	if err != nil {
+		return 0, ErrNotMap
+	}
+	if i < 0 {
+		i += length
+	}
+	return i, nil
+}
+
+// keySegment a segment of key path, index is true if it's like [0]
+type keySegment struct {
+	name  string
+	index bool
+}
+
+// parseKeyPath parse key into segments, supported:
+// dot separator: a.b.c; list index: servers.0.host, servers[0].host, servers[-1];
+// quoted segments: hosts."api.example.com".port, hosts["api.example.com"];
+// escaped dots: hosts.api\.example\.com
+func parseKeyPath(key string) ([]keySegment, error) {
+	var segs []keySegment
+	var name strings.Builder
+	// named: name is set even if it's empty; bracketed: the last segment is closed by ]
+	named, bracketed := false, false
+
+	flush := func() {
+		segs = append(segs, keySegment{name: name.String()})
+		name.Reset()
+		named = false
+	}
+
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c != '.' && c != '[' && bracketed {
+			return nil, ErrInvalidKey
+		}
+		switch {
+		case c == '\\':
+			if i+1 >= len(key) {
+				return nil, ErrInvalidKey
+			}
+			i++
+			name.WriteByte(key[i])
+			named = true
+		case (c == '"' || c == '\'') && name.Len() == 0 && !named:
+			s, n, err := unquoteKeySegment(key[i:])
+			if err != nil {
+				return nil, err
+			}
+			name.WriteString(s)
+			named = true
+			i += n - 1
+		case c == '.':
+			if named || name.Len() > 0 {
+				flush()
+			} else if !bracketed {
+				return nil, ErrInvalidKey
+			}
+			bracketed = false
+		case c == '[':
+			if named || name.Len() > 0 {
+				flush()
+			}
+			end := i + 1
+			if end < len(key) && (key[end] == '"' || key[end] == '\'') {
+				s, n, err := unquoteKeySegment(key[end:])
 				if err != nil {
-					return err
+					return nil, err
 				}
-				err = p.setKeyValue(strings.Join(keys, "."), vm)
-				if err != nil {
-					return err
+				end += n
+				if end >= len(key) || key[end] != ']' {
+					return nil, ErrInvalidKey
+				}
+				segs = append(segs, keySegment{name: s})
+			} else {
+				for end < len(key) && key[end] != ']' {
+					end++
 				}
+				if end >= len(key) {
+					return nil, ErrInvalidKey
+				}
+				index := strings.TrimSpace(key[i+1 : end])
+				if _, err := strconv.Atoi(index); err != nil {
+					return nil, ErrInvalidKey
+				}
+				segs = append(segs, keySegment{name: index, index: true})
 			}
+			i, bracketed = end, true
+		default:
+			name.WriteByte(c)
 		}
 	}
-	return nil
+
+	if named || name.Len() > 0 {
+		flush()
+	} else if len(segs) == 0 || strings.HasSuffix(key, ".") {
+		return nil, ErrInvalidKey
+	}
+	return segs, nil
 }
 
-func (p *AdapterConfig) getKeyValue(key string) (interface{}, error) {
+// unquoteKeySegment unquote the quoted segment at the start of s, return it and the length of quoted text
+func unquoteKeySegment(s string) (string, int, error) {
+	quote := s[0]
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, ErrInvalidKey
+			}
+			i++
+			sb.WriteByte(s[i])
+		case quote:
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return "", 0, ErrInvalidKey
+}
+
+// splitKey split key into segments' names
+func splitKey(key string) []string {
+	segs, err := parseKeyPath(key)
+	if err != nil {
+		return strings.Split(key, ".")
+	}
+	tokens := make([]string, 0, len(segs))
+	for _, seg := range segs {
+		tokens = append(tokens, seg.name)
+	}
+	return tokens
+}
 
-	tokens := strings.Split(key, ".")
-	vm := p.configs[tokens[0]]
+// joinKey join segments' names into key, quote the names which contain special characters
+func joinKey(tokens []string) string {
+	var sb strings.Builder
 	for i, t := range tokens {
-		if i == 0 {
-			continue
+		if i > 0 {
+			sb.WriteByte('.')
 		}
+		sb.WriteString(quoteKeySegment(t))
+	}
+	return sb.String()
+}
 
-		switch v := vm.(type) {
-		case Options:
-			vm = v[t]
-		case map[string]interface{}:
-			vm = v[t]
-		case map[interface{}]interface{}:
-			vm = v[t]
-		default:
-			return nil, ErrNotMap
-		}
+// childKey return the key of the parent's child
+func childKey(parent, name string) string {
+	if parent == "" {
+		return quoteKeySegment(name)
 	}
-	return vm, nil
+	return parent + "." + quoteKeySegment(name)
 }
 
-// setKeyValue set key value into *configs
-func (p *AdapterConfig) setKeyValue(key string, value interface{}) (err error) {
-	tokens := strings.Split(key, ".")
-	for i := len(tokens) - 1; i >= 0; i-- {
-		if i == 0 {
-			p.configs[tokens[0]] = value
-			return
-		}
-		v, _ := p.getKeyValue(strings.Join(tokens[:i], "."))
-		switch vm := v.(type) {
-		case Options:
-			vm[tokens[i]] = value
-			value = vm
-		case map[string]interface{}:
-			vm[tokens[i]] = value
-			value = vm
-		case map[interface{}]interface{}:
-			vm[tokens[i]] = value
-			value = vm
-		default:
-			value = map[string]interface{}{tokens[i]: value}
+// canonicalKey return the key in form of dot separated segments, exp: servers[0] => servers.0
+func canonicalKey(key string) string {
+	return joinKey(splitKey(key))
+}
+
+// quoteKeySegment quote the name if it contains special characters
+func quoteKeySegment(name string) string {
+	if name != "" && !strings.ContainsAny(name, ".[]\"'\\") {
+		return name
+	}
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		if name[i] == '"' || name[i] == '\\' {
+			sb.WriteByte('\\')
 		}
+		sb.WriteByte(name[i])
 	}
-	return
+	sb.WriteByte('"')
+	return sb.String()
 }
@@ -0,0 +1,388 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LayerRuntime name of the layer which holds values set by SetKeyValue
+const LayerRuntime = "runtime"
+
+// configLayer one source of configs, layers are merged by their order,
+// the later one has the higher precedence
+type configLayer struct {
+	name string
+
+	file   string
+	dir    string
+	glob   string
+	str    string
+	st     interface{}
+	values map[string]interface{}
+
+	readerType ReaderType
+
+	data      []byte
+	reader    Reader
+	document  interface{}
+	configs   map[string]interface{}
+	positions map[string]Position
+	includes  []string
+
+	// generated layers are built from the loaded layers, exp: env, flags
+	generated bool
+	overrides []EnvOverride
+}
+
+// source return a new layer with the same source, but not loaded
+func (p *configLayer) source() *configLayer {
+	return &configLayer{
+		name:       p.name,
+		file:       p.file,
+		dir:        p.dir,
+		glob:       p.glob,
+		str:        p.str,
+		st:         p.st,
+		values:     p.values,
+		readerType: p.readerType,
+	}
+}
+
+// load read and parse the layer's source
+func (p *configLayer) load(opts []ReaderOptionFunc) (err error) {
+	if len(p.file) > 0 {
+		p.readerType = fileToReaderType(p.file)
+
+		p.data, err = readFile(p.file)
+		if err != nil {
+			return
+		}
+	}
+
+	if p.values != nil {
+		if p.readerType == ReaderTypeSuffix {
+			p.readerType = ReaderTypeYAML
+		}
+		if p.reader, err = newConfigReader(p.readerType, p.file, opts); err != nil {
+			return
+		}
+		p.configs = DeepCopy(p.values).(map[string]interface{})
+		return nil
+	}
+
+	if p.reader, err = newConfigReader(p.readerType, p.file, opts); err != nil {
+		return
+	}
+
+	if len(p.str) > 0 {
+		p.data = []byte(p.str)
+	}
+
+	if p.st != nil {
+		p.data, err = p.reader.Dump(p.st)
+		if err != nil {
+			return err
+		}
+	}
+
+	if pr, ok := p.reader.(PositionReader); ok && p.st == nil {
+		p.positions, err = pr.ParseDataPositions(p.data, &p.configs)
+	} else {
+		err = p.reader.ParseData(p.data, &p.configs)
+	}
+	if err != nil {
+		return
+	}
+
+	if p.configs == nil {
+		p.configs = make(map[string]interface{})
+	}
+	if p.st != nil {
+		return nil
+	}
+
+	// the parsed document to edit in place
+	if dr, ok := p.reader.(documentReader); ok {
+		if p.document, err = dr.parseDocument(p.data); err != nil {
+			return
+		}
+	}
+	return p.resolveIncludes(opts)
+}
+
+// watchFiles return the files which the layer is loaded from, with the included files
+func (p *configLayer) watchFiles() []string {
+	if len(p.file) == 0 {
+		return p.includes
+	}
+	return append([]string{p.file}, p.includes...)
+}
+
+// newConfigReader return a reader which can parse data into map[string]interface{}
+func newConfigReader(rt ReaderType, filename string, opts []ReaderOptionFunc) (Reader, error) {
+	opts = append([]ReaderOptionFunc{ReaderOptionFilename(filename)}, opts...)
+	switch rt {
+	case ReaderTypeJSON:
+		return NewJSONReader(opts...), nil
+	case ReaderTypeYAML:
+		return NewYAMLReader(opts...), nil
+	case ReaderTypeXML:
+		return NewXMLReader(opts...), nil
+	case ReaderTypeTOML:
+		return NewTOMLReader(opts...), nil
+	case ReaderTypeDotEnv:
+		return NewDotEnvReader(opts...), nil
+	case ReaderTypeProperties:
+		return NewPropertiesReader(opts...), nil
+	case ReaderTypeINI:
+		return NewINIReader(opts...), nil
+	default:
+		return nil, ErrNotSupportedReaderType
+	}
+}
+
+// loadLayers load all layers, return the loaded layers,
+// a directory is expanded into the layers of its files
+func loadLayers(sources []*configLayer, opts []ReaderOptionFunc) ([]*configLayer, error) {
+	layers := make([]*configLayer, 0, len(sources))
+	for _, s := range sources {
+		if len(s.dir) == 0 {
+			layers = append(layers, s.source())
+			continue
+		}
+
+		files, err := dirFiles(s.dir, s.glob)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			layers = append(layers, &configLayer{name: f, file: f})
+		}
+	}
+
+	for _, l := range layers {
+		if err := l.load(opts); err != nil {
+			return nil, err
+		}
+	}
+	return layers, nil
+}
+
+// dirFiles return the files in dir which match the glob, in lexical order
+func dirFiles(dir, glob string) ([]string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	if len(glob) == 0 {
+		glob = "*"
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, glob))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if fi, err := os.Stat(m); err == nil && !fi.IsDir() {
+			files = append(files, m)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// keyValue a value set into key at runtime
+type keyValue struct {
+	key   string
+	value interface{}
+}
+
+// appendKeyValue append the key's value, and remove the older values overridden by it
+func appendKeyValue(kvs []keyValue, key string, value interface{}) []keyValue {
+	key = canonicalKey(key)
+	result := kvs[:0]
+	for _, kv := range kvs {
+		if kv.key != key && !strings.HasPrefix(kv.key, key+".") {
+			result = append(result, kv)
+		}
+	}
+	return append(result, keyValue{key: key, value: value})
+}
+
+func copyKeyValues(kvs []keyValue) []keyValue {
+	result := make([]keyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		result = append(result, keyValue{key: kv.key, value: DeepCopy(kv.value)})
+	}
+	return result
+}
+
+// applyKeyValues set the runtime values into configs by order
+func applyKeyValues(configs map[string]interface{}, kvs []keyValue) error {
+	for _, kv := range kvs {
+		if err := setMapKeyValue(configs, kv.key, DeepCopy(kv.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeLayers merge layers' configs by order, then the runtime values
+func mergeLayers(layers []*configLayer, runtime []keyValue) (map[string]interface{}, error) {
+	configs := make(map[string]interface{})
+	for _, l := range layers {
+		deepMerge(configs, l.configs)
+	}
+	if err := applyKeyValues(configs, runtime); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// overlayLayer return a generated layer of the values set into configs,
+// a value in a list replaces the outermost list in the layer, because lists are not merged
+func overlayLayer(name string, configs map[string]interface{}, kvs []keyValue) (*configLayer, error) {
+	if err := applyKeyValues(configs, kvs); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	for _, kv := range kvs {
+		tokens := splitKey(kv.key)
+		anchor := kv.key
+		for i := 1; i < len(tokens); i++ {
+			v, _, err := lookupMapKeyValue(configs, joinKey(tokens[:i]))
+			if _, isList := v.([]interface{}); err == nil && isList {
+				anchor = joinKey(tokens[:i])
+				break
+			}
+		}
+
+		v, _, err := lookupMapKeyValue(configs, anchor)
+		if err != nil {
+			return nil, err
+		}
+		if err = setMapKeyValue(values, anchor, DeepCopy(v)); err != nil {
+			return nil, err
+		}
+	}
+	return &configLayer{name: name, configs: values, generated: true}, nil
+}
+
+// primaryLayer return the layer which decides the reader of the config:
+// the last layer loaded from a file, or the last layer except generated ones, or an empty yaml layer
+func primaryLayer(layers []*configLayer) *configLayer {
+	for i := len(layers) - 1; i >= 0; i-- {
+		if len(layers[i].file) > 0 {
+			return layers[i]
+		}
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		if !layers[i].generated {
+			return layers[i]
+		}
+	}
+	return &configLayer{readerType: ReaderTypeYAML, reader: NewYAMLReader()}
+}
+
+// layerOf return the name of the layer which supplies the key's value
+func layerOf(layers []*configLayer, runtime []keyValue, key string) string {
+	key = canonicalKey(key)
+	if runtimeDefines(runtime, key) {
+		return LayerRuntime
+	}
+	if l := definingLayer(layers, key); l != nil {
+		return l.name
+	}
+	return ""
+}
+
+// runtimeDefines judge if the canonical key is set by SetKeyValue
+func runtimeDefines(runtime []keyValue, key string) bool {
+	for _, kv := range runtime {
+		if key == kv.key || strings.HasPrefix(key, kv.key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// definingLayer return the top layer which defines the canonical key
+func definingLayer(layers []*configLayer, key string) *configLayer {
+	for i := len(layers) - 1; i >= 0; i-- {
+		if definesKey(layers[i].configs, key) {
+			return layers[i]
+		}
+	}
+	return nil
+}
+
+// definesKey judge if the configs has the key, or a value at the key's parents,
+// which may be expanded into the key by ${...}
+func definesKey(configs map[string]interface{}, key string) bool {
+	if len(configs) == 0 {
+		return false
+	}
+	_, ok, err := lookupMapKeyValue(configs, key)
+	if err == nil {
+		return ok
+	}
+
+	tokens := splitKey(key)
+	for i := len(tokens) - 1; i > 0; i-- {
+		v, ok, err := lookupMapKeyValue(configs, joinKey(tokens[:i]))
+		if err != nil || !ok {
+			continue
+		}
+		_, isMap := toStringMap(v)
+		return !isMap
+	}
+	return false
+}
+
+// KeyConflict a key defined by more than one layer, layers are in order of precedence from low to high
+type KeyConflict struct {
+	Key    string
+	Layers []string
+}
+
+// Conflicts return the leaf keys defined by more than one layer, exp: fragments of a directory
+func (p *AdapterConfig) Conflicts() []KeyConflict {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
+	defined := make(map[string][]string)
+	for _, l := range p.layers {
+		for k := range flattenConfigs(l.configs) {
+			defined[k] = append(defined[k], l.name)
+		}
+	}
+
+	var conflicts []KeyConflict
+	for k, names := range defined {
+		if len(names) > 1 {
+			conflicts = append(conflicts, KeyConflict{Key: k, Layers: names})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Key < conflicts[j].Key })
+	return conflicts
+}
@@ -0,0 +1,199 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"math/big"
+	"time"
+)
+
+// Lookup return the value in p.configs by key, and whether it exists
+func (p *AdapterConfig) Lookup(key string) (interface{}, bool) {
+	v, err := p.GetInterfaceE(key)
+	return v, err == nil
+}
+
+// getInterfaceE return the key's value of c, or KeyNotFoundError,
+// Configs which don't implement Getter report null values as missing
+func getInterfaceE(c Config, key string) (interface{}, error) {
+	if g, ok := c.(Getter); ok {
+		return g.GetInterfaceE(key)
+	}
+	v := c.GetInterface(key)
+	if v == nil {
+		return nil, &KeyNotFoundError{Key: key}
+	}
+	return v, nil
+}
+
+// GetInterfaceE return the value in p.configs by key,
+// or KeyNotFoundError if it's missing or null, KeyTypeError if its parent is not a map or list
+func (p *AdapterConfig) GetInterfaceE(key string) (interface{}, error) {
+	segs, err := parseKeyPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
+	var vm interface{} = p.configs
+	for i, seg := range segs {
+		if vm == nil {
+			return nil, &KeyNotFoundError{Key: key}
+		}
+
+		v, ok, err := childValue(vm, seg)
+		if err != nil {
+			parent := make([]string, 0, i)
+			for _, s := range segs[:i] {
+				parent = append(parent, s.name)
+			}
+			return nil, &KeyTypeError{Key: joinKey(parent), Expected: "map or list", Value: vm}
+		}
+		if !ok {
+			return nil, &KeyNotFoundError{Key: key}
+		}
+		vm = v
+	}
+
+	if vm == nil {
+		return nil, &KeyNotFoundError{Key: key}
+	}
+	return vm, nil
+}
+
+// GetStringE return a string in p.configs by key, numbers and bools are formatted
+func (p *AdapterConfig) GetStringE(key string) (string, error) {
+	v, err := p.GetInterfaceE(key)
+	if err != nil {
+		return "", err
+	}
+	s, err := convertString(v)
+	if err != nil {
+		return "", keyError(key, v, "string", err)
+	}
+	return s, nil
+}
+
+// GetBooleanE return a bool in p.configs by key, strings like on, off, true, false, yes, no are parsed
+func (p *AdapterConfig) GetBooleanE(key string) (bool, error) {
+	v, err := p.GetInterfaceE(key)
+	if err != nil {
+		return false, err
+	}
+	b, err := convertBool(v)
+	if err != nil {
+		return false, keyError(key, v, "bool", err)
+	}
+	return b, nil
+}
+
+// GetIntE return a int in p.configs by key, numeric strings are parsed
+func (p *AdapterConfig) GetIntE(key string) (int, error) {
+	v, err := p.GetInterfaceE(key)
+	if err != nil {
+		return 0, err
+	}
+	i, err := convertInt64(v)
+	if err == nil && int64(int(i)) != i {
+		err = errOutOfRange
+	}
+	if err != nil {
+		return 0, keyError(key, v, "int", err)
+	}
+	return int(i), nil
+}
+
+// GetFloatE return a float in p.configs by key, numeric strings are parsed
+func (p *AdapterConfig) GetFloatE(key string) (float64, error) {
+	v, err := p.GetInterfaceE(key)
+	if err != nil {
+		return 0, err
+	}
+	f, err := convertFloat64(v)
+	if err != nil {
+		return 0, keyError(key, v, "float", err)
+	}
+	return f, nil
+}
+
+// GetTimeDurationE return time duration in p.configs by key, exp: 1s, 1day, 1h30m
+func (p *AdapterConfig) GetTimeDurationE(key string) (time.Duration, error) {
+	v, err := p.GetInterfaceE(key)
+	if err != nil {
+		return 0, err
+	}
+	d, err := convertDuration(v)
+	if err != nil {
+		return 0, keyError(key, v, "duration", err)
+	}
+	return d, nil
+}
+
+// GetTimeE return time in p.configs by key, exp: 2006-01-02T15:04:05Z07:00, 2006-01-02
+func (p *AdapterConfig) GetTimeE(key string) (time.Time, error) {
+	v, err := p.GetInterfaceE(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := convertTime(v)
+	if err != nil {
+		return time.Time{}, keyError(key, v, "time", err)
+	}
+	return t, nil
+}
+
+// GetByteSizeE return byte size in p.configs by key, exp: 1k, 1m
+func (p *AdapterConfig) GetByteSizeE(key string) (*big.Int, error) {
+	v, err := p.GetInterfaceE(key)
+	if err != nil {
+		return nil, err
+	}
+	size, err := convertByteSize(v)
+	if err != nil {
+		return nil, keyError(key, v, "byte size", err)
+	}
+	return size, nil
+}
+
+// GetListE return a list in p.configs by key
+func (p *AdapterConfig) GetListE(key string) ([]interface{}, error) {
+	v, err := p.GetInterfaceE(key)
+	if err != nil {
+		return nil, err
+	}
+	l, err := convertList(v)
+	if err != nil {
+		return nil, keyError(key, v, "list", err)
+	}
+	return l, nil
+}
+
+// GetMapE return a map in p.configs by key
+func (p *AdapterConfig) GetMapE(key string) (Options, error) {
+	v, err := p.GetInterfaceE(key)
+	if err != nil {
+		return nil, err
+	}
+	m, err := convertMap(v)
+	if err != nil {
+		return nil, keyError(key, v, "map", err)
+	}
+	return m, nil
+}
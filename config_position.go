@@ -0,0 +1,126 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// Position the location of a key in its source, line and column start from 1
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// IsValid judge if the position is known
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	s := p.File
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return s
+}
+
+// PositionReader reader which can record the positions of keys while parsing,
+// positions are keyed by dotted keys, exp: servers.0.host
+type PositionReader interface {
+	Reader
+	// parse data to model, and return the keys' positions
+	ParseDataPositions(data []byte, model interface{}) (map[string]Position, error)
+}
+
+// sourceLines the beginnings of lines in data, to find the positions of offsets
+type sourceLines struct {
+	file   string
+	data   []byte
+	starts []int
+}
+
+func newSourceLines(file string, data []byte) *sourceLines {
+	starts := []int{0}
+	for i, c := range data {
+		if c == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return &sourceLines{file: file, data: data, starts: starts}
+}
+
+// position return the position of the byte offset
+func (p *sourceLines) position(offset int) Position {
+	if offset > len(p.data) {
+		offset = len(p.data)
+	}
+	line := sort.Search(len(p.starts), func(i int) bool { return p.starts[i] > offset })
+	start := p.starts[line-1]
+	return Position{File: p.file, Line: line, Column: utf8.RuneCount(p.data[start:offset]) + 1}
+}
+
+// Origin return the position where the key's value is defined,
+// false if it's unknown, exp: set by SetKeyValue or parsed by a reader without positions
+func (p *AdapterConfig) Origin(key string) (Position, bool) {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+	return originOf(p.layers, p.runtime, key)
+}
+
+// originOf return the position of the key in the layer which supplies it,
+// or the position of its nearest parent
+func originOf(layers []*configLayer, runtime []keyValue, key string) (Position, bool) {
+	key = canonicalKey(key)
+	if runtimeDefines(runtime, key) {
+		return Position{}, false
+	}
+	l := definingLayer(layers, key)
+	if l == nil {
+		return Position{}, false
+	}
+	tokens := splitKey(key)
+	for n := len(tokens); n > 0; n-- {
+		if pos, ok := l.positions[joinKey(tokens[:n])]; ok {
+			return pos, true
+		}
+	}
+	return Position{}, false
+}
+
+// annotateError set the keys' positions into interpolation and validation errors
+func annotateError(layers []*configLayer, runtime []keyValue, err error) error {
+	var ie *InterpolationError
+	if errors.As(err, &ie) {
+		ie.Position, _ = originOf(layers, runtime, ie.Key)
+	}
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		for i := range ve.Violations {
+			ve.Violations[i].Position, _ = originOf(layers, runtime, ve.Violations[i].Key)
+		}
+	}
+	return err
+}
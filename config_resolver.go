@@ -0,0 +1,120 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/iTrellis/common/errors"
+)
+
+// Resolver return the value of a namespaced reference like ${name:arg}
+type Resolver func(arg string) (interface{}, error)
+
+var (
+	resolversLocker sync.RWMutex
+	resolvers       = map[string]Resolver{
+		"env":    resolveEnv,
+		"file":   resolveFile,
+		"base64": resolveBase64,
+		"sys":    resolveSys,
+	}
+)
+
+// RegisterResolver register a resolver for references like ${name:arg},
+// it replaces the registered one with the same name.
+// built-in: env, file, base64, sys
+func RegisterResolver(name string, fn func(arg string) (interface{}, error)) {
+	resolversLocker.Lock()
+	defer resolversLocker.Unlock()
+	if fn == nil {
+		delete(resolvers, name)
+		return
+	}
+	resolvers[name] = fn
+}
+
+// lookupResolver return the resolver and its argument if key is like name:arg
+func lookupResolver(key string) (Resolver, string, bool) {
+	i := strings.Index(key, ":")
+	if i <= 0 {
+		return nil, "", false
+	}
+
+	resolversLocker.RLock()
+	defer resolversLocker.RUnlock()
+	fn, ok := resolvers[key[:i]]
+	return fn, key[i+1:], ok
+}
+
+// resolveEnv ${env:HOME}
+func resolveEnv(name string) (interface{}, error) {
+	return os.Getenv(name), nil
+}
+
+// resolveFile ${file:/run/secrets/db_pass}, the file's content without tailing line breaks
+func resolveFile(name string) (interface{}, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// resolveBase64 ${base64:aGVsbG8=}
+func resolveBase64(s string) (interface{}, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		if data, err = base64.RawStdEncoding.DecodeString(s); err != nil {
+			return nil, err
+		}
+	}
+	return string(data), nil
+}
+
+// resolveSys ${sys:hostname}, supported: hostname, os, arch, pid, cpus, cwd, user
+func resolveSys(name string) (interface{}, error) {
+	switch name {
+	case "hostname":
+		return os.Hostname()
+	case "os":
+		return runtime.GOOS, nil
+	case "arch":
+		return runtime.GOARCH, nil
+	case "pid":
+		return os.Getpid(), nil
+	case "cpus":
+		return runtime.NumCPU(), nil
+	case "cwd":
+		return os.Getwd()
+	case "user":
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		return u.Username, nil
+	default:
+		return nil, errors.Newf("unknown sys value: %s", name)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Save write the primary file layer's configs with the values set by SetKeyValue back into its file,
+// it refuses to overwrite the file if it's changed since loaded
+func (p *AdapterConfig) Save() error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	l := primaryLayer(p.layers)
+	if l == nil || len(l.file) == 0 {
+		return ErrNoConfigFile
+	}
+	return p.saveLayer(l, l.file)
+}
+
+// SaveAs write the primary layer's configs with the values set by SetKeyValue into the path
+func (p *AdapterConfig) SaveAs(path string) error {
+	if len(path) == 0 {
+		return ErrInvalidFilePath
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	l := primaryLayer(p.layers)
+	if l == nil {
+		return ErrNoConfigFile
+	}
+	return p.saveLayer(l, path)
+}
+
+// documentReader reader which can set values into the data in place,
+// to keep its comments and formatting
+type documentReader interface {
+	// parse data into the document to edit
+	parseDocument(data []byte) (interface{}, error)
+	// return the data of the document with the values set by order, the document is not changed
+	editDocument(doc interface{}, kvs []keyValue) ([]byte, error)
+}
+
+// editable judge if the layer's data can be edited in place
+func (p *configLayer) editable() bool {
+	return p.document != nil
+}
+
+// dumpLayer return the layer's data with the values,
+// the data is edited in place if the reader supports
+func dumpLayer(l *configLayer, kvs []keyValue) ([]byte, error) {
+	if l.editable() {
+		return l.reader.(documentReader).editDocument(l.document, kvs)
+	}
+	// the included files' configs would be written into the layer's file
+	if len(l.includes) > 0 {
+		return nil, ErrIncludesNotSaved
+	}
+
+	configs := DeepCopy(l.configs).(map[string]interface{})
+	if err := applyKeyValues(configs, kvs); err != nil {
+		return nil, err
+	}
+	return l.reader.Dump(configs)
+}
+
+// layerEdits return the values which make the layer's configs the merged configs of layers,
+// false if any key of the layer is removed, which can't be edited
+func layerEdits(layers []*configLayer, l *configLayer) ([]keyValue, bool, error) {
+	configs, err := mergeLayers(layers, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var kvs []keyValue
+	for _, c := range diffConfigs(l.configs, configs) {
+		if c.Type == ChangeRemoved {
+			return nil, false, nil
+		}
+		kvs = append(kvs, keyValue{key: c.Key, value: c.NewValue})
+	}
+	return kvs, true, nil
+}
+
+func (p *AdapterConfig) saveLayer(l *configLayer, path string) error {
+	data, err := dumpLayer(l, p.runtime)
+	if err != nil {
+		return err
+	}
+
+	loaded := len(l.file) > 0 && sameFile(l.file, path)
+	if loaded {
+		current, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil && !bytes.Equal(current, l.data) {
+			return ErrFileChanged
+		}
+	}
+
+	if err = writeFileAtomic(path, data, p.saveBackup); err != nil {
+		return err
+	}
+	if loaded {
+		l.data = data
+		if l.editable() {
+			l.document, err = l.reader.(documentReader).parseDocument(data)
+		}
+	}
+	return err
+}
+
+// sameFile judge if the paths are the same file
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// writeFileAtomic write data into a temp file in the same directory, then rename it to path,
+// the permissions of the existing file are kept, and it's copied to path.bak in the same way if backup
+func writeFileAtomic(path string, data []byte, backup bool) error {
+	perm := os.FileMode(0644)
+	fi, err := os.Stat(path)
+	switch {
+	case err == nil:
+		perm = fi.Mode().Perm()
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	if backup && err == nil {
+		old, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err = replaceFile(path+".bak", old, perm); err != nil {
+			return err
+		}
+	}
+	return replaceFile(path, data, perm)
+}
+
+// replaceFile write data into a temp file in the same directory, and rename it to path,
+// then flush the directory, so that the renamed file is kept after a crash
+func replaceFile(path string, data []byte, perm os.FileMode) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flush the directory's entries
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,352 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/iTrellis/common/errors"
+)
+
+// maxSchemaRefs the max depth of $ref without descending into values
+const maxSchemaRefs = 32
+
+// jsonSchema a subset of JSON Schema draft 2020-12:
+// type, enum, const, pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+// minLength, maxLength, minItems, maxItems, items, required, properties,
+// additionalProperties and $ref into the same document
+type jsonSchema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// parseSchema parse the schema in json
+func parseSchema(data []byte) (*jsonSchema, error) {
+	var root interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, errors.Newf("invalid schema: %s", err)
+	}
+	switch root.(type) {
+	case map[string]interface{}, bool:
+	default:
+		return nil, errors.New("invalid schema: should be an object or a boolean")
+	}
+
+	s := &jsonSchema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.compilePatterns(root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compilePatterns compile all patterns in the schema
+func (p *jsonSchema) compilePatterns(node interface{}) error {
+	switch t := node.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			if k == "pattern" {
+				if s, ok := v.(string); ok {
+					re, err := regexp.Compile(s)
+					if err != nil {
+						return errors.Newf("invalid schema pattern %q: %s", s, err)
+					}
+					p.patterns[s] = re
+					continue
+				}
+			}
+			if err := p.compilePatterns(v); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, v := range t {
+			if err := p.compilePatterns(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validate return all violations of the configs
+func (p *jsonSchema) validate(configs map[string]interface{}) error {
+	ve := &ValidationError{}
+	p.check(ve, "", configs, p.root, 0)
+	return ve.orNil()
+}
+
+func (p *jsonSchema) check(ve *ValidationError, key string, v interface{}, node interface{}, refs int) {
+	add := func(rule, format string, args ...interface{}) {
+		ve.Violations = append(ve.Violations, Violation{Key: key, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch t := node.(type) {
+	case bool:
+		if !t {
+			add("false", "is not allowed")
+		}
+		return
+	case map[string]interface{}:
+	default:
+		return
+	}
+	s := node.(map[string]interface{})
+
+	if ref, ok := s["$ref"].(string); ok {
+		target, err := p.resolveRef(ref)
+		switch {
+		case err != nil:
+			add("$ref", "%s", err)
+		case refs >= maxSchemaRefs:
+			add("$ref", "too many nested references: %s", ref)
+		default:
+			p.check(ve, key, v, target, refs+1)
+		}
+	}
+
+	if types, ok := s["type"]; ok && !schemaTypeMatches(types, v) {
+		add("type", "expected %s, got %s", schemaTypeString(types), schemaTypeOf(v))
+		return
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		matched := false
+		for _, e := range enum {
+			if schemaEqual(e, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			add("enum", "%v is not one of %v", v, enum)
+		}
+	}
+	if c, ok := s["const"]; ok && !schemaEqual(c, v) {
+		add("const", "%v is not %v", v, c)
+	}
+
+	switch value := v.(type) {
+	case string:
+		n := float64(utf8.RuneCountInString(value))
+		if limit, ok := schemaNumber(s["minLength"]); ok && n < limit {
+			add("minLength", "length %v is less than %v", n, limit)
+		}
+		if limit, ok := schemaNumber(s["maxLength"]); ok && n > limit {
+			add("maxLength", "length %v is greater than %v", n, limit)
+		}
+		if pattern, ok := s["pattern"].(string); ok && !p.patterns[pattern].MatchString(value) {
+			add("pattern", "%q does not match %q", value, pattern)
+		}
+		return
+	}
+
+	if n, ok := schemaNumber(v); ok {
+		if limit, ok := schemaNumber(s["minimum"]); ok && n < limit {
+			add("minimum", "%v is less than %v", v, limit)
+		}
+		if limit, ok := schemaNumber(s["maximum"]); ok && n > limit {
+			add("maximum", "%v is greater than %v", v, limit)
+		}
+		if limit, ok := schemaNumber(s["exclusiveMinimum"]); ok && n <= limit {
+			add("exclusiveMinimum", "%v is not greater than %v", v, limit)
+		}
+		if limit, ok := schemaNumber(s["exclusiveMaximum"]); ok && n >= limit {
+			add("exclusiveMaximum", "%v is not less than %v", v, limit)
+		}
+		return
+	}
+
+	if l, err := convertList(v); err == nil {
+		n := float64(len(l))
+		if limit, ok := schemaNumber(s["minItems"]); ok && n < limit {
+			add("minItems", "%v items are less than %v", n, limit)
+		}
+		if limit, ok := schemaNumber(s["maxItems"]); ok && n > limit {
+			add("maxItems", "%v items are greater than %v", n, limit)
+		}
+		if items, ok := s["items"]; ok {
+			for i, item := range l {
+				p.check(ve, childKey(key, fmt.Sprint(i)), item, items, 0)
+			}
+		}
+		return
+	}
+
+	m, ok := toStringMap(v)
+	if !ok {
+		return
+	}
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := m[name]; !ok {
+				ve.Violations = append(ve.Violations, Violation{Key: childKey(key, name), Rule: "required", Message: "is required"})
+			}
+		}
+	}
+	properties, _ := s["properties"].(map[string]interface{})
+	additional, hasAdditional := s["additionalProperties"]
+	for _, name := range sortedKeys(m) {
+		if prop, ok := properties[name]; ok {
+			p.check(ve, childKey(key, name), m[name], prop, 0)
+			continue
+		}
+		if hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				ve.Violations = append(ve.Violations, Violation{Key: childKey(key, name), Rule: "additionalProperties", Message: "is not allowed"})
+				continue
+			}
+			p.check(ve, childKey(key, name), m[name], additional, 0)
+		}
+	}
+}
+
+// resolveRef find the schema of the reference, exp: #/$defs/port
+func (p *jsonSchema) resolveRef(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, errors.Newf("not supported reference: %s", ref)
+	}
+	pointer, err := url.PathUnescape(ref[1:])
+	if err != nil {
+		return nil, errors.Newf("invalid reference: %s", ref)
+	}
+
+	node := p.root
+	if pointer == "" {
+		return node, nil
+	}
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, errors.Newf("reference not found: %s", ref)
+		}
+		if node, ok = m[token]; !ok {
+			return nil, errors.Newf("reference not found: %s", ref)
+		}
+	}
+	return node, nil
+}
+
+// schemaNumber return the float value of numbers, strings are not numbers
+func schemaNumber(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case nil, string, bool:
+		return 0, false
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	}
+	return ruleNumber(reflect.ValueOf(v))
+}
+
+// schemaTypeOf return the json type name of the value
+func schemaTypeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case time.Time:
+		return "string"
+	}
+	if n, ok := schemaNumber(v); ok {
+		if n == math.Trunc(n) && !math.IsInf(n, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	if isList(reflect.ValueOf(v)) {
+		return "array"
+	}
+	if _, ok := toStringMap(v); ok {
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func schemaTypeMatches(types, v interface{}) bool {
+	actual := schemaTypeOf(v)
+	match := func(t interface{}) bool {
+		return t == actual || (t == "number" && actual == "integer")
+	}
+	if l, ok := types.([]interface{}); ok {
+		for _, t := range l {
+			if match(t) {
+				return true
+			}
+		}
+		return false
+	}
+	return match(types)
+}
+
+func schemaTypeString(types interface{}) string {
+	if l, ok := types.([]interface{}); ok {
+		names := make([]string, 0, len(l))
+		for _, t := range l {
+			names = append(names, fmt.Sprint(t))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(types)
+}
+
+// schemaEqual compare values in json semantic, numbers are compared by value
+func schemaEqual(a, b interface{}) bool {
+	if na, ok := schemaNumber(a); ok {
+		nb, ok := schemaNumber(b)
+		return ok && na == nb
+	}
+	if la, err := convertList(a); err == nil {
+		lb, err := convertList(b)
+		if err != nil || len(la) != len(lb) {
+			return false
+		}
+		for i := range la {
+			if !schemaEqual(la[i], lb[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if ma, ok := toStringMap(a); ok {
+		mb, ok := toStringMap(b)
+		if !ok || len(ma) != len(mb) {
+			return false
+		}
+		for k, va := range ma {
+			vb, ok := mb[k]
+			if !ok || !schemaEqual(va, vb) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,255 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// checkStrict return UnknownKeysError if configs have keys unknown by the strict target or the schema
+func (p *AdapterConfig) checkStrict(configs map[string]interface{}) error {
+	if !p.strict {
+		return nil
+	}
+
+	ue := &UnknownKeysError{}
+	switch {
+	case p.strictTarget != nil:
+		structUnknownKeys(ue, "", configs, reflect.TypeOf(p.strictTarget))
+	case p.schema != nil:
+		p.schema.unknownKeys(ue, "", configs, p.schema.root, 0)
+	}
+	return ue.orNil()
+}
+
+// structField a field's key name, type and tag
+type structField struct {
+	name string
+	typ  reflect.Type
+	tag  reflect.StructTag
+}
+
+// structFields return the fields which can be bound, embedded structs are squashed
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts := fieldTag(f)
+		if name == "-" {
+			continue
+		}
+
+		if (f.Anonymous && name == "") || hasTagOption(opts, "squash", "inline") {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fields = append(fields, structFields(ft)...)
+				continue
+			}
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{name: name, typ: f.Type, tag: f.Tag})
+	}
+	return fields
+}
+
+// structUnknownKeys collect the keys of v which are not mapped to the fields of type t
+func structUnknownKeys(ue *UnknownKeysError, key string, v interface{}, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case typeDuration, typeTime, typeBigInt:
+		return
+	}
+	if reflect.PtrTo(t).Implements(typeTextUnmarshaler) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := toStringMap(v)
+		if !ok {
+			return
+		}
+		fields := structFields(t)
+		names := make([]string, 0, len(fields))
+		for _, f := range fields {
+			names = append(names, f.name)
+		}
+
+		for _, k := range sortedKeys(m) {
+			var field *structField
+			for i := range fields {
+				if fields[i].name == k {
+					field = &fields[i]
+					break
+				}
+			}
+			for i := 0; field == nil && i < len(fields); i++ {
+				if strings.EqualFold(fields[i].name, k) {
+					field = &fields[i]
+				}
+			}
+
+			if field == nil {
+				addUnknownKeys(ue, key, k, m[k], names)
+				continue
+			}
+			structUnknownKeys(ue, childKey(key, k), m[k], field.typ)
+		}
+	case reflect.Slice, reflect.Array:
+		l, err := convertList(v)
+		if err != nil {
+			structUnknownKeys(ue, key, v, t.Elem())
+			return
+		}
+		for i, item := range l {
+			structUnknownKeys(ue, childKey(key, strconv.Itoa(i)), item, t.Elem())
+		}
+	case reflect.Map:
+		m, ok := toStringMap(v)
+		if !ok {
+			return
+		}
+		for _, k := range sortedKeys(m) {
+			structUnknownKeys(ue, childKey(key, k), m[k], t.Elem())
+		}
+	}
+}
+
+// unknownKeys collect the keys of v which are not in the schema's properties
+func (p *jsonSchema) unknownKeys(ue *UnknownKeysError, key string, v interface{}, node interface{}, refs int) {
+	s, ok := node.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if ref, ok := s["$ref"].(string); ok && refs < maxSchemaRefs {
+		if target, err := p.resolveRef(ref); err == nil {
+			p.unknownKeys(ue, key, v, target, refs+1)
+		}
+		return
+	}
+
+	if l, err := convertList(v); err == nil {
+		if items, ok := s["items"]; ok {
+			for i, item := range l {
+				p.unknownKeys(ue, childKey(key, strconv.Itoa(i)), item, items, 0)
+			}
+		}
+		return
+	}
+
+	m, ok := toStringMap(v)
+	if !ok {
+		return
+	}
+	properties, hasProperties := s["properties"].(map[string]interface{})
+	additional, hasAdditional := s["additionalProperties"]
+	if !hasProperties {
+		return
+	}
+	names := sortedKeys(properties)
+	for _, k := range sortedKeys(m) {
+		if prop, ok := properties[k]; ok {
+			p.unknownKeys(ue, childKey(key, k), m[k], prop, 0)
+			continue
+		}
+		if allowed, ok := additional.(bool); !hasAdditional || (ok && !allowed) {
+			addUnknownKeys(ue, key, k, m[k], names)
+			continue
+		}
+		p.unknownKeys(ue, childKey(key, k), m[k], additional, 0)
+	}
+}
+
+// addUnknownKeys add the unknown name's leaf keys, suggest the similar name of the known names
+func addUnknownKeys(ue *UnknownKeysError, key, name string, v interface{}, names []string) {
+	suggestion := similarName(name, names)
+
+	leaves := []string{""}
+	if m, ok := toStringMap(v); ok && len(m) > 0 {
+		leaves = sortedKeys(flattenConfigs(m))
+	}
+	for _, leaf := range leaves {
+		uk := UnknownKey{Key: childKey(key, name)}
+		if suggestion != "" {
+			uk.Suggestion = childKey(key, suggestion)
+		}
+		if leaf != "" {
+			uk.Key += "." + leaf
+			if uk.Suggestion != "" {
+				uk.Suggestion += "." + leaf
+			}
+		}
+		ue.Keys = append(ue.Keys, uk)
+	}
+}
+
+// similarName return the most similar name by edit distance, or empty if none is similar
+func similarName(name string, names []string) string {
+	best, bestDistance := "", len(name)/3+2
+	for _, n := range names {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(n)); d < bestDistance {
+			best, bestDistance = n, d
+		}
+	}
+	return best
+}
+
+// editDistance return the levenshtein distance of a and b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
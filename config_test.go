@@ -18,8 +18,18 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package config_test
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -159,3 +169,1328 @@ func testFunc(t *testing.T, c config.Config) {
 	bs, _ := c.Dump()
 	testutils.Assert(t, bs != nil, "dump config should not be nil")
 }
+
+func TestLayeredConfig(t *testing.T) {
+	c, err := config.NewConfigOptions(
+		config.OptionLayerOptions("defaults", config.Options{
+			"server": map[string]interface{}{"port": 80, "host": "localhost"},
+			"level":  "info",
+		}),
+		config.OptionLayerFile("base", yamlFile),
+		config.OptionLayerString("host", config.ReaderTypeJSON, `{"server": {"port": 8080}, "h": 2.02}`),
+	)
+	testutils.Ok(t, err)
+
+	testutils.Equals(t, 8080, c.GetInt("server.port"))
+	testutils.Equals(t, "localhost", c.GetString("server.host"))
+	testutils.Equals(t, "Easy!", c.GetString("a"))
+	testutils.Equals(t, 2.02, c.GetFloat("h"))
+	testutils.Equals(t, "test", c.GetString("b.c.cn.a"))
+
+	testutils.Equals(t, "host", c.(config.Inspector).LayerOf("server.port"))
+	testutils.Equals(t, "defaults", c.(config.Inspector).LayerOf("server.host"))
+	testutils.Equals(t, "base", c.(config.Inspector).LayerOf("b.c.cn.a"))
+	testutils.Equals(t, "", c.(config.Inspector).LayerOf("not.exist"))
+
+	testutils.Ok(t, c.SetKeyValue("server.host", "0.0.0.0"))
+	testutils.Equals(t, "0.0.0.0", c.GetString("server.host"))
+	testutils.Equals(t, config.LayerRuntime, c.(config.Inspector).LayerOf("server.host"))
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	testutils.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "watch.yml")
+	testutils.Ok(t, ioutil.WriteFile(name, []byte("db:\n  pool: 10\n"), 0644))
+
+	errs := make(chan error, 1)
+	c, err := config.NewConfigOptions(
+		config.OptionFile(name),
+		config.OptionWatch(10*time.Millisecond),
+		config.OptionWatchErrorHandler(func(e error) { errs <- e }),
+	)
+	testutils.Ok(t, err)
+	defer c.(config.Reloader).StopWatch()
+	testutils.Equals(t, 10, c.GetInt("db.pool"))
+
+	// same size edit
+	replaceFile(t, name, "db:\n  pool: 20\n")
+	waitFor(t, func() bool { return c.GetInt("db.pool") == 20 })
+
+	replaceFile(t, name, "db: [pool: 30\n")
+	select {
+	case e := <-errs:
+		testutils.NotOk(t, e)
+	case <-time.After(time.Second):
+		t.Fatal("reload error should be reported")
+	}
+	testutils.Equals(t, 20, c.GetInt("db.pool"))
+}
+
+// replaceFile write data into a temp file and rename it to name, like editors do
+func replaceFile(t *testing.T, name, data string) {
+	tmp := name + ".tmp"
+	testutils.Ok(t, ioutil.WriteFile(tmp, []byte(data), 0644))
+	testutils.Ok(t, os.Rename(tmp, name))
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition is not satisfied in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML,
+		"db:\n  pool:\n    size: 10\n  host: localhost\nlevel: info\n"))
+	testutils.Ok(t, err)
+
+	var events []config.ChangeEvent
+	cancel := c.(config.Reloader).OnChange("db.pool.size", func(e config.ChangeEvent) { events = append(events, e) })
+
+	testutils.Ok(t, c.SetKeyValue("level", "debug"))
+	testutils.Equals(t, 0, len(events))
+
+	testutils.Ok(t, c.SetKeyValue("db.pool.size", 20))
+	testutils.Equals(t, 1, len(events))
+	testutils.Equals(t, []config.KeyChange{{
+		Key: "db.pool.size", Type: config.ChangeModified, OldValue: 10, NewValue: 20,
+	}}, events[0].Changes)
+
+	var all []config.KeyChange
+	c.(config.Reloader).OnChange("", func(e config.ChangeEvent) { all = append(all, e.Changes...) })
+	testutils.Ok(t, c.SetKeyValue("db", map[string]interface{}{"port": 3306}))
+	testutils.Equals(t, 2, len(events))
+	testutils.Equals(t, 3, len(all))
+	testutils.Equals(t, "db.host", all[0].Key)
+	testutils.Equals(t, config.ChangeRemoved, all[0].Type)
+	testutils.Equals(t, 1, len(events[1].Removed()))
+	testutils.Equals(t, config.ChangeAdded, all[2].Type)
+
+	cancel()
+	testutils.Ok(t, c.SetKeyValue("db.pool.size", 30))
+	testutils.Equals(t, 2, len(events))
+}
+
+func TestTOMLConfig(t *testing.T) {
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeTOML, `
+title = "TOML"
+ref = "${owner.name}"
+
+[owner]
+name = "Tom"
+dob = 1979-05-27T07:32:00Z
+day = 1979-05-27
+alarm = 07:32:00
+
+[database]
+ports = [8000, 8001]
+enabled = true
+timeout = "30s"
+`))
+	testutils.Ok(t, err)
+
+	testutils.Equals(t, "TOML", c.GetString("title"))
+	testutils.Equals(t, "Tom", c.GetString("ref"))
+	testutils.Equals(t, []int{8000, 8001}, c.GetIntList("database.ports"))
+	testutils.Assert(t, c.GetBoolean("database.enabled"), "database.enabled should be true")
+	testutils.Equals(t, 30*time.Second, c.GetTimeDuration("database.timeout"))
+	testutils.Equals(t, 7*time.Hour+32*time.Minute, c.GetTimeDuration("owner.alarm"))
+	testutils.Equals(t, time.Date(1979, 5, 27, 7, 32, 0, 0, time.UTC), c.(config.Getter).GetTime("owner.dob").UTC())
+	testutils.Equals(t, 27, c.(config.Getter).GetTime("owner.day").Day())
+	testutils.Equals(t, time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), c.(config.Getter).GetTime("title", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)))
+
+	bs, err := c.Dump()
+	testutils.Ok(t, err)
+	testutils.Assert(t, bytes.Contains(bs, []byte(`title = "TOML"`)), "dump should be toml")
+
+	r, err := config.NewSuffixReader(config.ReaderOptionFilename("config.toml"))
+	testutils.Ok(t, err)
+	var model struct {
+		Title string `toml:"title"`
+	}
+	testutils.Ok(t, r.ParseData(bs, &model))
+	testutils.Equals(t, "TOML", model.Title)
+}
+
+func TestXMLConfig(t *testing.T) {
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeXML, `<?xml version="1.0"?>
+<app>
+  <name>example</name>
+  <server id="main" enabled="true">
+    <host>localhost</host>
+    <port>8080</port>
+  </server>
+  <ports><port>80</port><port>443</port></ports>
+  <timeout unit="s">30</timeout>
+  <ref>${server.host}</ref>
+</app>`))
+	testutils.Ok(t, err)
+
+	testutils.Equals(t, "example", c.GetString("name"))
+	testutils.Equals(t, 8080, c.GetInt("server.port"))
+	testutils.Equals(t, "main", c.GetString("server.@id"))
+	testutils.Assert(t, c.GetBoolean("server.@enabled"), "server.@enabled should be true")
+	testutils.Equals(t, []int{80, 443}, c.GetIntList("ports.port"))
+	testutils.Equals(t, "30", c.GetString("timeout.#text"))
+	testutils.Equals(t, "localhost", c.GetString("ref"))
+
+	bs, err := c.Dump()
+	testutils.Ok(t, err)
+	testutils.Assert(t, bytes.Contains(bs, []byte(`<server enabled="true" id="main">`)), "dump should keep attributes")
+
+	r := config.NewXMLReader()
+	tree := map[string]interface{}{}
+	testutils.Ok(t, r.ParseData(bs, &tree))
+	testutils.Equals(t, "main", tree["server"].(map[string]interface{})["@id"])
+	testutils.Equals(t, []interface{}{"80", "443"}, tree["ports"].(map[string]interface{})["port"])
+
+	// the root of the last parsed data is dumped
+	testutils.Ok(t, r.ParseData([]byte(`<svc><port>1</port></svc>`), &tree))
+	bs, err = r.Dump(tree)
+	testutils.Ok(t, err)
+	testutils.Assert(t, bytes.Contains(bs, []byte("<svc>")), "dump should use the last root: %s", bs)
+
+	c, err = config.NewConfigOptions(
+		config.OptionString(config.ReaderTypeXML, `<c><s id="1">v</s></c>`),
+		config.OptionReaderOptions(config.ReaderOptionXMLAttrPrefix("_"), config.ReaderOptionXMLTextKey("value")))
+	testutils.Ok(t, err)
+	testutils.Equals(t, "1", c.GetString("s._id"))
+	testutils.Equals(t, "v", c.GetString("s.value"))
+}
+
+func TestInterpolation(t *testing.T) {
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, `
+db:
+  host: localhost
+  port: 5432
+  empty: ""
+url: jdbc://${db.host}:${db.port}/app
+fallback: ${db.user:-root}@${db.empty:-${db.host}}
+literal: $${db.host} is ${db.host}
+port: ${db.port}
+`))
+	testutils.Ok(t, err)
+
+	testutils.Equals(t, "jdbc://localhost:5432/app", c.GetString("url"))
+	testutils.Equals(t, "root@localhost", c.GetString("fallback"))
+	testutils.Equals(t, "${db.host} is localhost", c.GetString("literal"))
+	testutils.Equals(t, 5432, c.GetInterface("port"))
+
+	_, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML,
+		"pass: ${db.pass:?database password is required}"))
+	testutils.NotOk(t, err)
+	var ie *config.InterpolationError
+	testutils.Assert(t, errors.As(err, &ie), "error should be InterpolationError")
+	testutils.Equals(t, "pass", ie.Key)
+	testutils.Assert(t, strings.Contains(err.Error(), "database password is required"), err.Error())
+
+	_, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, "a: ${b"))
+	testutils.NotOk(t, err)
+}
+
+func TestInterpolationOrder(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, `
+a: ${b}/a
+b: ${c}/b
+c: ${d.e}
+d:
+  e: root
+  f: ${b}
+z: ${d}
+`))
+		testutils.Ok(t, err)
+		testutils.Equals(t, "root/b/a", c.GetString("a"))
+		testutils.Equals(t, "root/b", c.GetString("z.f"))
+	}
+
+	_, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, `
+a: ${b}
+b: x-${c.d}
+c:
+  d: ${a}
+`))
+	testutils.NotOk(t, err)
+	var ce *config.ReferenceCycleError
+	testutils.Assert(t, errors.As(err, &ce), "error should be ReferenceCycleError")
+	testutils.Equals(t, "reference cycle: a -> b -> c.d -> a", err.Error())
+}
+
+func TestResolvers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	testutils.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	secret := filepath.Join(dir, "db_pass")
+	testutils.Ok(t, ioutil.WriteFile(secret, []byte("s3cret\n"), 0600))
+	t.Setenv("CONFIG_TEST_HOME", "/home/test")
+	hostname, _ := os.Hostname()
+
+	config.RegisterResolver("upper", func(arg string) (interface{}, error) {
+		return strings.ToUpper(arg), nil
+	})
+	defer config.RegisterResolver("upper", nil)
+
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, `
+home: ${env:CONFIG_TEST_HOME}
+missing: ${env:CONFIG_TEST_MISSING:-none}
+pass: ${file:`+secret+`}
+decoded: ${base64:aGVsbG8=}
+host: ${sys:hostname}
+name: ${upper:app}-${sys:os}
+`))
+	testutils.Ok(t, err)
+	testutils.Equals(t, "/home/test", c.GetString("home"))
+	testutils.Equals(t, "none", c.GetString("missing"))
+	testutils.Equals(t, "s3cret", c.GetString("pass"))
+	testutils.Equals(t, "hello", c.GetString("decoded"))
+	testutils.Equals(t, hostname, c.GetString("host"))
+	testutils.Equals(t, "APP-"+runtime.GOOS, c.GetString("name"))
+
+	_, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML,
+		"pass: ${file:"+filepath.Join(dir, "not_exist")+"}"))
+	testutils.NotOk(t, err)
+}
+
+func TestKeyPath(t *testing.T) {
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, `
+servers:
+  - host: a.example.com
+    port: 80
+  - host: b.example.com
+    port: 81
+hosts:
+  api.example.com:
+    port: 443
+first: ${servers[0].host}
+`))
+	testutils.Ok(t, err)
+
+	testutils.Equals(t, "a.example.com", c.GetString("servers.0.host"))
+	testutils.Equals(t, "a.example.com", c.GetString("servers[0].host"))
+	testutils.Equals(t, 81, c.GetInt("servers[-1].port"))
+	testutils.Equals(t, 81, c.GetInt("servers.-1.port"))
+	testutils.Equals(t, "", c.GetString("servers[2].host"))
+	testutils.Equals(t, 443, c.GetInt(`hosts."api.example.com".port`))
+	testutils.Equals(t, 443, c.GetInt(`hosts["api.example.com"].port`))
+	testutils.Equals(t, 443, c.GetInt(`hosts.api\.example\.com.port`))
+	testutils.Equals(t, "a.example.com", c.GetString("first"))
+
+	testutils.Ok(t, c.SetKeyValue("servers[1].host", "c.example.com"))
+	testutils.Equals(t, "c.example.com", c.GetString("servers.1.host"))
+	testutils.Ok(t, c.SetKeyValue("servers[2]", map[string]interface{}{"host": "d.example.com"}))
+	testutils.Equals(t, "d.example.com", c.GetString("servers[-1].host"))
+	testutils.NotOk(t, c.SetKeyValue("servers[5].host", "e"))
+	testutils.Ok(t, c.SetKeyValue(`hosts."api.example.com".port`, 8443))
+	testutils.Equals(t, 8443, c.GetInt(`hosts["api.example.com"].port`))
+	testutils.Equals(t, config.LayerRuntime, c.(config.Inspector).LayerOf("servers.1.host"))
+	testutils.Ok(t, c.SetKeyValue("list[0].a", 1))
+	testutils.Equals(t, 1, c.GetInt("list.0.a"))
+
+	testutils.Ok(t, c.(config.Reloader).Reload())
+	testutils.Equals(t, "c.example.com", c.GetString("servers.1.host"))
+	testutils.Equals(t, "d.example.com", c.GetString("servers.-1.host"))
+	testutils.Equals(t, 8443, c.GetInt(`hosts["api.example.com"].port`))
+}
+
+func TestGettersE(t *testing.T) {
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, `
+server:
+  port: 8080
+  host: localhost
+  debug: "yes"
+  timeout: 1m30s
+  size: 10mb
+  started: 2020-01-02T03:04:05Z
+  bad: abc
+  tags: [a, b]
+empty: ~
+`))
+	testutils.Ok(t, err)
+
+	port, err := c.(config.Getter).GetIntE("server.port")
+	testutils.Ok(t, err)
+	testutils.Equals(t, 8080, port)
+	s, err := c.(config.Getter).GetStringE("server.port")
+	testutils.Ok(t, err)
+	testutils.Equals(t, "8080", s)
+	b, err := c.(config.Getter).GetBooleanE("server.debug")
+	testutils.Ok(t, err)
+	testutils.Assert(t, b, "server.debug should be true")
+	testutils.Assert(t, c.GetBoolean("server.debug"), "GetBoolean should parse bools like GetBooleanE")
+	testutils.Assert(t, c.GetBoolean("server.bad", true), "invalid bools should return the default value")
+	d, err := c.(config.Getter).GetTimeDurationE("server.timeout")
+	testutils.Ok(t, err)
+	testutils.Equals(t, 90*time.Second, d)
+	size, err := c.(config.Getter).GetByteSizeE("server.size")
+	testutils.Ok(t, err)
+	testutils.Equals(t, int64(10*1000*1000), size.Int64())
+	tm, err := c.(config.Getter).GetTimeE("server.started")
+	testutils.Ok(t, err)
+	testutils.Equals(t, 2020, tm.Year())
+	l, err := c.(config.Getter).GetListE("server.tags")
+	testutils.Ok(t, err)
+	testutils.Equals(t, 2, len(l))
+	_, ok := c.(config.Getter).Lookup("server.host")
+	testutils.Assert(t, ok, "server.host should exist")
+
+	var nf *config.KeyNotFoundError
+	_, err = c.(config.Getter).GetIntE("sever.port")
+	testutils.Assert(t, errors.As(err, &nf), "sever.port should be not found")
+	testutils.Equals(t, "sever.port", nf.Key)
+	_, err = c.(config.Getter).GetIntE("empty")
+	testutils.Assert(t, errors.As(err, &nf), "empty should be not found")
+
+	var te *config.KeyTypeError
+	_, err = c.(config.Getter).GetIntE("server.tags")
+	testutils.Assert(t, errors.As(err, &te), "server.tags should be wrong type")
+	testutils.Equals(t, "server.tags", te.Key)
+	_, err = c.(config.Getter).GetStringE("server.host.name")
+	testutils.Assert(t, errors.As(err, &te), "server.host.name should be wrong type")
+	testutils.Equals(t, "server.host", te.Key)
+
+	var pe *config.KeyParseError
+	_, err = c.(config.Getter).GetIntE("server.bad")
+	testutils.Assert(t, errors.As(err, &pe), "server.bad should be unparsable")
+	testutils.Equals(t, "server.bad", pe.Key)
+	_, err = c.(config.Getter).GetTimeDurationE("server.bad")
+	testutils.Assert(t, errors.As(err, &pe), "server.bad should be unparsable duration")
+}
+
+func TestGenericGetters(t *testing.T) {
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, `
+server:
+  port: 8080
+  ip: 127.0.0.1
+  timeout: 2s
+  ports: [80, "443"]
+  limits:
+    a: 1
+    b: 2
+`))
+	testutils.Ok(t, err)
+
+	testutils.Equals(t, uint16(8080), config.Get[uint16](c, "server.port"))
+	testutils.Equals(t, 2*time.Second, config.Get[time.Duration](c, "server.timeout"))
+	testutils.Equals(t, []int{80, 443}, config.Get[[]int](c, "server.ports"))
+	testutils.Equals(t, map[string]int64{"a": 1, "b": 2}, config.Get[map[string]int64](c, "server.limits"))
+	testutils.Equals(t, "127.0.0.1", config.Get[net.IP](c, "server.ip").String())
+	testutils.Equals(t, int8(1), config.Get[int8](c, "server.port", 1))
+
+	_, err = config.GetE[int8](c, "server.port")
+	var pe *config.KeyParseError
+	testutils.Assert(t, errors.As(err, &pe), "server.port should overflow int8")
+	_, err = config.GetE[fmt.Stringer](c, "server.ip")
+	var te *config.KeyTypeError
+	testutils.Assert(t, errors.As(err, &te), "string should not be a fmt.Stringer")
+	testutils.Equals(t, "127.0.0.1", config.Get[interface{}](c, "server.ip"))
+
+	config.Key[int]("server.port").Default(1).Register()
+	port := config.Key[int]("server.port").Default(80).Description("listen port").Register()
+	testutils.Equals(t, 8080, port.Get(c))
+	workers := config.Key[int]("server.workers").Default(4)
+	n, err := workers.GetE(c)
+	testutils.Ok(t, err)
+	testutils.Equals(t, 4, n)
+
+	var found int
+	for _, k := range config.RegisteredKeys() {
+		switch k.Name {
+		case "server.port":
+			found++
+			testutils.Equals(t, config.KeyInfo{Name: "server.port", Type: "int", Default: 80, Description: "listen port"}, k)
+		case "server.workers":
+			t.Fatal("server.workers isn't registered")
+		}
+	}
+	testutils.Equals(t, 1, found)
+}
+
+type bindBase struct {
+	Name string `config:"name" default:"app"`
+}
+
+type bindServer struct {
+	Host    string        `yaml:"host"`
+	Port    int           `json:"port" default:"80"`
+	Timeout time.Duration `config:"timeout" default:"30s"`
+}
+
+type bindModel struct {
+	bindBase
+	Server   *bindServer  `config:"server"`
+	Backends []bindServer `config:"backends"`
+	MaxSize  int64        `config:"max_size,bytesize"`
+	IP       net.IP       `config:"ip"`
+	Tags     []string     `default:"a,b"`
+	Debug    bool
+}
+
+func TestToObject(t *testing.T) {
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, `
+server:
+  host: localhost
+  timeout: 1m
+backends:
+  - host: a
+    port: 81
+  - host: b
+max_size: 10kb
+ip: 10.0.0.1
+debug: true
+`))
+	testutils.Ok(t, err)
+
+	var m bindModel
+	testutils.Ok(t, c.ToObject("", &m))
+	testutils.Equals(t, "app", m.Name)
+	testutils.Equals(t, bindServer{Host: "localhost", Port: 80, Timeout: time.Minute}, *m.Server)
+	testutils.Equals(t, []bindServer{{Host: "a", Port: 81, Timeout: 30 * time.Second}, {Host: "b", Port: 80, Timeout: 30 * time.Second}}, m.Backends)
+	testutils.Equals(t, int64(10*1000), m.MaxSize)
+	testutils.Equals(t, "10.0.0.1", m.IP.String())
+	testutils.Equals(t, []string{"a", "b"}, m.Tags)
+	testutils.Assert(t, m.Debug, "debug should be true")
+	testutils.NotOk(t, c.ToObject("", m))
+
+	x, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeXML,
+		`<config><server><host>x</host><port>8080</port></server><backends><host>c</host></backends></config>`))
+	testutils.Ok(t, err)
+
+	var xm bindModel
+	testutils.Ok(t, x.ToObject("", &xm))
+	testutils.Equals(t, 8080, xm.Server.Port)
+	testutils.Equals(t, []bindServer{{Host: "c", Port: 80, Timeout: 30 * time.Second}}, xm.Backends)
+
+	var s bindServer
+	testutils.Ok(t, x.ToObject("server", &s))
+	testutils.Equals(t, "x", s.Host)
+	_, err = config.GetE[[]bindServer](x, "server")
+	testutils.NotOk(t, err)
+
+	// missing sections get their defaults and validation
+	var ms bindServer
+	testutils.Ok(t, c.ToObject("missing", &ms))
+	testutils.Equals(t, bindServer{Port: 80, Timeout: 30 * time.Second}, ms)
+	var vs validateServer
+	err = c.ToObject("missing", &vs)
+	var ve *config.ValidationError
+	testutils.Assert(t, errors.As(err, &ve), "ToObject should fail validation")
+	testutils.Equals(t, []config.Violation{{Key: "missing.host", Rule: "required", Message: "is required"}}, ve.Violations)
+
+	// case-insensitive names match the keys in order
+	for i := 0; i < 10; i++ {
+		y, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, "Host: b\nHOST: a\nhosT: c\n"))
+		testutils.Ok(t, err)
+		testutils.Ok(t, y.ToObject("", &s))
+		testutils.Equals(t, "a", s.Host)
+	}
+}
+
+type validateServer struct {
+	Host  string `config:"host" validate:"required"`
+	Port  int    `config:"port" validate:"min=1,max=65535"`
+	Level string `config:"level" default:"info" validate:"oneof=debug info"`
+}
+
+type validateModel struct {
+	Servers []validateServer `config:"servers" validate:"min=1"`
+	Name    string           `config:"name" validate:"required,len=3"`
+}
+
+func TestValidate(t *testing.T) {
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, `
+servers:
+  - host: a
+    port: 80
+  - port: 70000
+    level: trace
+name: ab
+`))
+	testutils.Ok(t, err)
+
+	var m validateModel
+	err = c.ToObject("", &m)
+	var ve *config.ValidationError
+	testutils.Assert(t, errors.As(err, &ve), "ToObject should fail validation")
+	testutils.Equals(t, []config.Violation{
+		{Key: "servers.1.host", Rule: "required", Message: "is required"},
+		{Key: "servers.1.port", Rule: "max", Message: "70000 is greater than 65535", Position: config.Position{Line: 5, Column: 5}},
+		{Key: "servers.1.level", Rule: "oneof", Message: "trace is not one of [debug info]", Position: config.Position{Line: 6, Column: 5}},
+		{Key: "name", Rule: "len", Message: "length 2 is not 3", Position: config.Position{Line: 7, Column: 1}},
+	}, ve.Violations)
+
+	err = c.(config.Validator).Validate(map[string]string{
+		"servers.0.port": "min=1,max=65535",
+		"servers.1.port": "max=65535",
+		"timeout":        "required",
+	})
+	testutils.Assert(t, errors.As(err, &ve), "Validate should fail")
+	testutils.Equals(t, 2, len(ve.Violations))
+	testutils.Equals(t, "servers.1.port", ve.Violations[0].Key)
+	testutils.Equals(t, "timeout", ve.Violations[1].Key)
+
+	// strings are validated as they are, numeric ones are numbers only for min and max
+	c, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeJSON,
+		`{"zip": "01234", "code": "01", "ratio": "nan", "port": "70000"}`))
+	testutils.Ok(t, err)
+	err = c.(config.Validator).Validate(map[string]string{
+		"zip":   "len=5",
+		"code":  "oneof=01 02",
+		"ratio": "max=10",
+		"port":  "min=1,max=65535",
+	})
+	testutils.Assert(t, errors.As(err, &ve), "Validate should fail")
+	testutils.Equals(t, 1, len(ve.Violations))
+	testutils.Equals(t, "70000 is greater than 65535", ve.Violations[0].Message)
+
+	// json numbers are validated by their values, not their digits
+	c, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeJSON,
+		`{"server": {"port": 70000, "backup": 0, "ratio": 0.5}}`))
+	testutils.Ok(t, err)
+	err = c.(config.Validator).Validate(map[string]string{
+		"server.port":   "min=1,max=65535",
+		"server.backup": "min=1",
+		"server.ratio":  "max=0.25",
+	})
+	testutils.Assert(t, errors.As(err, &ve), "Validate should fail")
+	testutils.Equals(t, []string{"0 is less than 1", "70000 is greater than 65535", "0.5 is greater than 0.25"},
+		[]string{ve.Violations[0].Message, ve.Violations[1].Message, ve.Violations[2].Message})
+	testutils.Ok(t, c.(config.Validator).Validate(map[string]string{"server.port": "min=100"}))
+}
+
+func TestSchema(t *testing.T) {
+	schema := []byte(`{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "required": ["server", "name"],
+  "additionalProperties": false,
+  "properties": {
+    "name": {"type": "string", "pattern": "^[a-z]+$"},
+    "level": {"enum": ["debug", "info"]},
+    "server": {
+      "type": "object",
+      "properties": {
+        "port": {"$ref": "#/$defs/port"},
+        "hosts": {"type": "array", "minItems": 1, "items": {"type": "string"}}
+      }
+    }
+  },
+  "$defs": {"port": {"type": "integer", "minimum": 1, "maximum": 65535}}
+}`)
+
+	_, err := config.NewConfigOptions(config.OptionSchema(schema), config.OptionString(config.ReaderTypeYAML, `
+name: App1
+level: trace
+debug: true
+server:
+  port: ${port}
+  hosts: [a, 1]
+port: 70000
+`))
+	var ve *config.ValidationError
+	testutils.Assert(t, errors.As(err, &ve), "load should fail validation")
+	keys := []string{}
+	for _, v := range ve.Violations {
+		keys = append(keys, v.Key+":"+v.Rule)
+	}
+	testutils.Equals(t, []string{
+		"debug:additionalProperties",
+		"level:enum",
+		"name:pattern",
+		"port:additionalProperties",
+		"server.hosts.1:type",
+		"server.port:maximum",
+	}, keys)
+
+	dir, err := ioutil.TempDir("", "config-schema")
+	testutils.Ok(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "app.json")
+	testutils.Ok(t, ioutil.WriteFile(name, []byte(`{"name": "app", "server": {"port": 80}}`), 0644))
+
+	c, err := config.NewConfigOptions(config.OptionSchema(schema), config.OptionFile(name))
+	testutils.Ok(t, err)
+	testutils.Ok(t, ioutil.WriteFile(name, []byte(`{"name": "app", "server": {"port": 0}}`), 0644))
+	err = c.(config.Reloader).Reload()
+	testutils.Assert(t, errors.As(err, &ve), "reload should fail validation")
+	testutils.Equals(t, "server.port", ve.Violations[0].Key)
+	testutils.Equals(t, 80, c.GetInt("server.port"))
+
+	err = c.SetKeyValue("server.port", 70000)
+	testutils.Assert(t, errors.As(err, &ve), "SetKeyValue should fail validation")
+	testutils.Equals(t, "server.port", ve.Violations[0].Key)
+	testutils.Equals(t, 80, c.GetInt("server.port"))
+	testutils.Ok(t, c.SetKeyValue("server.port", 8080))
+	testutils.Equals(t, 8080, c.GetInt("server.port"))
+}
+
+type strictModel struct {
+	Server struct {
+		Host string `config:"host"`
+		Port int    `config:"port"`
+	} `config:"server"`
+	Labels map[string]string `config:"labels"`
+}
+
+func TestStrict(t *testing.T) {
+	str := config.OptionString(config.ReaderTypeYAML, `
+sever:
+  port: 80
+server:
+  hots: localhost
+labels:
+  anything: ok
+debug: true
+`)
+	_, err := config.NewConfigOptions(str, config.OptionStrict(strictModel{}))
+	var ue *config.UnknownKeysError
+	testutils.Assert(t, errors.As(err, &ue), "load should fail in strict mode")
+	testutils.Equals(t, []config.UnknownKey{
+		{Key: "debug"},
+		{Key: "server.hots", Suggestion: "server.host"},
+		{Key: "sever.port", Suggestion: "server.port"},
+	}, ue.Keys)
+	testutils.Equals(t, "unknown keys: debug, server.hots (did you mean server.host?), sever.port (did you mean server.port?)", err.Error())
+
+	c, err := config.NewConfigOptions(str, config.OptionStrict(nil))
+	testutils.Ok(t, err)
+	var m strictModel
+	testutils.Assert(t, errors.As(c.ToObject("", &m), &ue), "ToObject should fail in strict mode")
+	testutils.Equals(t, 3, len(ue.Keys))
+	testutils.Ok(t, c.ToObject("labels", &m.Labels))
+
+	_, err = config.NewConfigOptions(str, config.OptionStrict(nil), config.OptionSchema([]byte(
+		`{"properties": {"server": {"properties": {"host": {}}}, "labels": {"additionalProperties": true}}}`)))
+	testutils.Assert(t, errors.As(err, &ue), "load should fail in strict mode with schema")
+	testutils.Equals(t, config.UnknownKey{Key: "server.hots", Suggestion: "server.host"}, ue.Keys[1])
+}
+
+func TestOrigin(t *testing.T) {
+	c, err := config.NewConfigOptions(config.OptionFile(jsonFile),
+		config.OptionLayerString("override", config.ReaderTypeYAML, "h: 2\nlist:\n  - a\n  - b: c\n"))
+	testutils.Ok(t, err)
+
+	pos, ok := c.(config.Inspector).Origin("b.c.f")
+	testutils.Assert(t, ok, "b.c.f should have origin")
+	testutils.Equals(t, config.Position{File: jsonFile, Line: 24, Column: 7}, pos)
+	pos, _ = c.(config.Inspector).Origin("b.d[1]")
+	testutils.Equals(t, "example.json:37:7", pos.String())
+	pos, _ = c.(config.Inspector).Origin("x")
+	testutils.Equals(t, 45, pos.Line)
+	pos, _ = c.(config.Inspector).Origin("h")
+	testutils.Equals(t, config.Position{Line: 1, Column: 1}, pos)
+	pos, _ = c.(config.Inspector).Origin("list.1.b")
+	testutils.Equals(t, config.Position{Line: 4, Column: 5}, pos)
+
+	testutils.Ok(t, c.SetKeyValue("h", 3))
+	_, ok = c.(config.Inspector).Origin("h")
+	testutils.Assert(t, !ok, "h set at runtime should have no origin")
+
+	_, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeJSON, "{\n  \"a\": 1,\n  \"b\" 2\n}"))
+	var pe *config.ParseError
+	testutils.Assert(t, errors.As(err, &pe), "json should fail to parse")
+	testutils.Equals(t, 3, pe.Position.Line)
+
+	_, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, "a: 1\nb: ${c:?c is required}\n"))
+	var ie *config.InterpolationError
+	testutils.Assert(t, errors.As(err, &ie), "interpolation should fail")
+	testutils.Equals(t, config.Position{Line: 2, Column: 1}, ie.Position)
+}
+
+func TestJSON5(t *testing.T) {
+	var v map[string]interface{}
+	err := config.ParseJSONConfig([]byte(`// header
+{
+  unquoted: 'single "quoted"',
+  "escaped": "a \"// not a comment\" é😀 /* nor this */",
+  hex: 0xFF,
+  neg: -.5,
+  pos: +5.,
+  inf: -Infinity,
+  list: [1, 2, /* three */ 3,],
+  "line": "a\
+b",
+  　"wide space": true, // U+3000 before the key
+}`), &v)
+	testutils.Ok(t, err)
+	testutils.Equals(t, `single "quoted"`, v["unquoted"])
+	testutils.Equals(t, "a \"// not a comment\" é\U0001F600 /* nor this */", v["escaped"])
+	testutils.Equals(t, json.Number("255"), v["hex"])
+	testutils.Equals(t, json.Number("-0.5"), v["neg"])
+	testutils.Equals(t, json.Number("5"), v["pos"])
+	testutils.Equals(t, math.Inf(-1), v["inf"])
+	testutils.Equals(t, []interface{}{json.Number("1"), json.Number("2"), json.Number("3")}, v["list"])
+	testutils.Equals(t, "ab", v["line"])
+	testutils.Equals(t, true, v["wide space"])
+
+	for data, pos := range map[string]config.Position{
+		"{\n  \"a\": 1\n  \"b\": 2\n}":   {Line: 3, Column: 3},
+		"{\n  \"a\": \"b\\\"\n}":         {Line: 2, Column: 8},
+		"{\n  \"a\": [1, 2x]\n}":         {Line: 2, Column: 12},
+		"{\n  /* comment\n  \"a\": 1\n}": {Line: 2, Column: 3},
+		"{\n  \"a\": tru\n}":             {Line: 2, Column: 8},
+	} {
+		err = config.ParseJSONConfig([]byte(data), &v)
+		var pe *config.ParseError
+		testutils.Assert(t, errors.As(err, &pe), "%q should fail to parse", data)
+		testutils.Equals(t, pos, pe.Position)
+	}
+
+	// NaN is unchanged when dumping, edited non-finite values are written as JSON5 literals
+	data := "{a: NaN, b: 1}"
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeJSON, data))
+	testutils.Ok(t, err)
+	bs, err := c.Dump()
+	testutils.Ok(t, err)
+	testutils.Equals(t, data, string(bs))
+	testutils.Ok(t, c.SetKeyValue("b", math.Inf(1)))
+	testutils.Ok(t, c.SetKeyValue("c", []interface{}{1, math.Inf(-1)}))
+	bs, err = c.Dump()
+	testutils.Ok(t, err)
+	testutils.Ok(t, config.ParseJSONConfig(bs, &v))
+	testutils.Assert(t, math.IsNaN(v["a"].(float64)), "a should be NaN")
+	testutils.Equals(t, math.Inf(1), v["b"])
+	testutils.Equals(t, []interface{}{json.Number("1"), math.Inf(-1)}, v["c"])
+}
+
+func TestSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-save")
+	testutils.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "app.yml")
+	testutils.Ok(t, ioutil.WriteFile(name, []byte("server:\n  port: 80\n  url: http://${server.host}\n  host: localhost\n"), 0600))
+
+	c, err := config.NewConfigOptions(config.OptionFile(name), config.OptionSaveBackup(),
+		config.OptionLayerOptions("defaults", config.Options{"debug": false}))
+	testutils.Ok(t, err)
+	testutils.Ok(t, c.SetKeyValue("server.port", 8080))
+	testutils.Ok(t, c.(config.Saver).Save())
+
+	saved, err := config.NewConfig(name)
+	testutils.Ok(t, err)
+	testutils.Equals(t, 8080, saved.GetInt("server.port"))
+	testutils.Equals(t, "http://localhost", saved.GetString("server.url"))
+	testutils.Equals(t, nil, saved.GetInterface("debug"))
+	fi, err := os.Stat(name)
+	testutils.Ok(t, err)
+	testutils.Equals(t, os.FileMode(0600), fi.Mode().Perm())
+	bak, err := ioutil.ReadFile(name + ".bak")
+	testutils.Ok(t, err)
+	testutils.Assert(t, strings.Contains(string(bak), "port: 80\n"), "backup should have the old port")
+	fi, err = os.Stat(name + ".bak")
+	testutils.Ok(t, err)
+	testutils.Equals(t, os.FileMode(0600), fi.Mode().Perm())
+
+	testutils.Ok(t, c.SetKeyValue("server.port", 9090))
+	testutils.Ok(t, c.(config.Saver).Save())
+	testutils.Ok(t, ioutil.WriteFile(name, []byte("server:\n  port: 1\n"), 0600))
+	testutils.Equals(t, config.ErrFileChanged, c.(config.Saver).Save())
+
+	other := filepath.Join(dir, "other.yml")
+	testutils.Ok(t, c.(config.Saver).SaveAs(other))
+	saved, err = config.NewConfig(other)
+	testutils.Ok(t, err)
+	testutils.Equals(t, 9090, saved.GetInt("server.port"))
+
+	s, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, "a: 1"))
+	testutils.Ok(t, err)
+	testutils.Equals(t, config.ErrNoConfigFile, s.(config.Saver).Save())
+
+	// the included configs can't be dumped into the including file
+	testutils.Ok(t, ioutil.WriteFile(filepath.Join(dir, "db.toml"), []byte("host = \"db\"\n"), 0600))
+	name = filepath.Join(dir, "app.toml")
+	testutils.Ok(t, ioutil.WriteFile(name, []byte("[db]\n\"$include\" = \"db.toml\"\n"), 0600))
+	c, err = config.NewConfig(name)
+	testutils.Ok(t, err)
+	testutils.Equals(t, "db", c.GetString("db.host"))
+	testutils.Equals(t, config.ErrIncludesNotSaved, c.(config.Saver).Save())
+}
+
+func TestRoundTrip(t *testing.T) {
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, `# service
+zeta: 1 # last in order
+defaults: &defaults
+  timeout: 5s
+server:
+  <<: *defaults
+  port: 80 # http
+  name: "api"
+alpha: [a, b]
+`))
+	testutils.Ok(t, err)
+	testutils.Ok(t, c.SetKeyValue("server.port", 8080))
+	testutils.Ok(t, c.SetKeyValue("server.tls.enabled", true))
+	testutils.Ok(t, c.SetKeyValue("alpha[2]", "c"))
+
+	bs, err := c.Dump()
+	testutils.Ok(t, err)
+	testutils.Equals(t, `# service
+zeta: 1 # last in order
+defaults: &defaults
+  timeout: 5s
+server:
+  <<: *defaults
+  port: 8080 # http
+  name: "api"
+  tls:
+    enabled: true
+alpha: [a, b, c]
+`, string(bs))
+
+	// blank lines are kept, values set through aliases don't change the anchors
+	c, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeYAML, `base: &base
+  timeout: 5s
+
+  retries: 3
+
+api: *base
+
+servers:
+  - name: a
+
+  - name: b
+`))
+	testutils.Ok(t, err)
+	testutils.Ok(t, c.SetKeyValue("api.timeout", "10s"))
+	testutils.Ok(t, c.SetKeyValue("servers.1.name", "c"))
+
+	bs, err = c.Dump()
+	testutils.Ok(t, err)
+	testutils.Equals(t, `base: &base
+  timeout: 5s
+
+  retries: 3
+
+api:
+  timeout: 10s
+  retries: 3
+
+servers:
+  - name: a
+
+  - name: c
+`, string(bs))
+
+	// values of the other layers are set into the primary layer
+	c, err = config.NewConfigOptions(
+		config.OptionLayerOptions("defaults", config.Options{"debug": false, "port": 1}),
+		config.OptionLayerString("app", config.ReaderTypeYAML, "# app\nport: 80 # http\n"),
+	)
+	testutils.Ok(t, err)
+	bs, err = c.Dump()
+	testutils.Ok(t, err)
+	testutils.Equals(t, "# app\nport: 80 # http\ndebug: false\n", string(bs))
+
+	c, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeJSON, `{
+  // service
+  "zeta": 1, /* last */
+  "server": {
+    "port": 80, // http
+    "hosts": ['a'],
+  },
+  "empty": {}
+}`))
+	testutils.Ok(t, err)
+	testutils.Ok(t, c.SetKeyValue("server.port", 8080))
+	testutils.Ok(t, c.SetKeyValue("server.hosts[1]", "b"))
+	testutils.Ok(t, c.SetKeyValue("server.tls.enabled", true))
+	testutils.Ok(t, c.SetKeyValue("empty.a", "<x>"))
+
+	bs, err = c.Dump()
+	testutils.Ok(t, err)
+	testutils.Equals(t, `{
+  // service
+  "zeta": 1, /* last */
+  "server": {
+    "port": 8080, // http
+    "hosts": ['a', "b"],
+    "tls": {
+      "enabled": true
+    },
+  },
+  "empty": {
+    "a": "<x>"
+  }
+}`, string(bs))
+}
+
+func TestDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-dir")
+	testutils.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "10-base.yml")
+	override := filepath.Join(dir, "20-override.json")
+	testutils.Ok(t, ioutil.WriteFile(base, []byte("server:\n  host: localhost\n  port: 80\nlevel: info\n"), 0644))
+	testutils.Ok(t, ioutil.WriteFile(override, []byte(`{"server": {"port": 8080}}`), 0644))
+	testutils.Ok(t, os.Mkdir(filepath.Join(dir, "sub.yml"), 0755))
+
+	c, err := config.NewConfigOptions(config.OptionDir(dir, "*"), config.OptionWatch(10*time.Millisecond))
+	testutils.Ok(t, err)
+	defer c.(config.Reloader).StopWatch()
+
+	testutils.Equals(t, 8080, c.GetInt("server.port"))
+	testutils.Equals(t, "localhost", c.GetString("server.host"))
+	testutils.Equals(t, override, c.(config.Inspector).LayerOf("server.port"))
+	testutils.Equals(t, base, c.(config.Inspector).LayerOf("server.host"))
+	testutils.Equals(t, []config.KeyConflict{{Key: "server.port", Layers: []string{base, override}}}, c.(config.Inspector).Conflicts())
+
+	testutils.Ok(t, ioutil.WriteFile(filepath.Join(dir, "30-extra.yml"), []byte("level: debug\n"), 0644))
+	waitFor(t, func() bool { return c.GetString("level") == "debug" })
+
+	_, err = config.NewConfigOptions(config.OptionDir(filepath.Join(dir, "missing"), "*.yml"))
+	testutils.NotOk(t, err)
+}
+
+func TestIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-include")
+	testutils.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	main := filepath.Join(dir, "main.yml")
+	db := filepath.Join(dir, "db.json")
+	testutils.Ok(t, os.Mkdir(filepath.Join(dir, "hosts"), 0755))
+	testutils.Ok(t, ioutil.WriteFile(main, []byte("app: demo\ndb: !include db.json\nservers: !include hosts/*.yml\n"), 0644))
+	testutils.Ok(t, ioutil.WriteFile(db, []byte("{\n  \"$include\": \"hosts/../pool.yml\",\n  \"host\": \"localhost\"\n}\n"), 0644))
+	testutils.Ok(t, ioutil.WriteFile(filepath.Join(dir, "pool.yml"), []byte("pool: 10\nhost: ignored\n"), 0644))
+	testutils.Ok(t, ioutil.WriteFile(filepath.Join(dir, "hosts", "a.yml"), []byte("a: 1\n"), 0644))
+	testutils.Ok(t, ioutil.WriteFile(filepath.Join(dir, "hosts", "b.yml"), []byte("b: 2\n"), 0644))
+
+	c, err := config.NewConfigOptions(config.OptionFile(main))
+	testutils.Ok(t, err)
+	testutils.Equals(t, "demo", c.GetString("app"))
+	testutils.Equals(t, "localhost", c.GetString("db.host"))
+	testutils.Equals(t, 10, c.GetInt("db.pool"))
+	testutils.Equals(t, 1, c.GetInt("servers.a"))
+	testutils.Equals(t, 2, c.GetInt("servers.b"))
+
+	pos, ok := c.(config.Inspector).Origin("db.host")
+	testutils.Assert(t, ok, "db.host should have a position")
+	testutils.Equals(t, config.Position{File: db, Line: 3, Column: 3}, pos)
+	pos, ok = c.(config.Inspector).Origin("db.pool")
+	testutils.Assert(t, ok, "db.pool should have a position")
+	testutils.Equals(t, filepath.Join(dir, "pool.yml"), pos.File)
+
+	// the include directives aren't replaced by saving
+	testutils.Ok(t, c.SetKeyValue("db.host", "db1"))
+	testutils.Equals(t, config.ErrIncludeNotEditable, c.(config.Saver).Save())
+	bs, err := c.Dump()
+	testutils.Ok(t, err)
+	testutils.Assert(t, strings.Contains(string(bs), "db1"), "dump should have the value: %s", bs)
+
+	testutils.Ok(t, ioutil.WriteFile(filepath.Join(dir, "pool.yml"), []byte("pool: !include main.yml\n"), 0644))
+	_, err = config.NewConfigOptions(config.OptionFile(main))
+	var ie *config.IncludeError
+	testutils.Assert(t, errors.As(err, &ie), "include cycle should return IncludeError: %v", err)
+	testutils.Assert(t, errors.Is(err, config.ErrIncludeCycle), "error should be ErrIncludeCycle: %v", err)
+	testutils.Equals(t, 4, len(ie.Chain))
+}
+
+func TestEnvOverlay(t *testing.T) {
+	t.Setenv("APP_DB_POOL_SIZE", "20")
+	t.Setenv("APP_DB_DEBUG", "true")
+	t.Setenv("APP_TAGS", "a, b,c")
+	t.Setenv("APP_SERVERS_0_HOST", "db1")
+	t.Setenv("APP_SERVERS_1_HOST", "db2")
+	t.Setenv("APP_SERVERS_1_PORT", "5433")
+	t.Setenv("APP_UNKNOWN", "ignored")
+
+	yml := `
+db:
+  pool-size: 10
+  debug: false
+tags: [x]
+servers:
+  - host: localhost
+    port: 5432
+`
+	c, err := config.NewConfigOptions(
+		config.OptionString(config.ReaderTypeYAML, yml),
+		config.OptionENVPrefix("APP"),
+		config.OptionEnvOverlay(""),
+	)
+	testutils.Ok(t, err)
+	testutils.Equals(t, 20, c.GetInt("db.pool-size"))
+	testutils.Equals(t, true, c.GetBoolean("db.debug"))
+	testutils.Equals(t, []string{"a", "b", "c"}, c.GetStringList("tags"))
+	testutils.Equals(t, "db1", c.GetString("servers.0.host"))
+	testutils.Equals(t, 5432, c.GetInt("servers.0.port"))
+	testutils.Equals(t, "db2", c.GetString("servers.1.host"))
+	testutils.Equals(t, 5433, c.GetInt("servers.1.port"))
+	testutils.Equals(t, config.LayerEnv, c.(config.Inspector).LayerOf("db.pool-size"))
+	testutils.Equals(t, []config.EnvOverride{
+		{Name: "APP_DB_DEBUG", Key: "db.debug", Value: "true"},
+		{Name: "APP_DB_POOL_SIZE", Key: "db.pool-size", Value: "20"},
+		{Name: "APP_SERVERS_0_HOST", Key: "servers.0.host", Value: "db1"},
+		{Name: "APP_SERVERS_1_HOST", Key: "servers.1.host", Value: "db2"},
+		{Name: "APP_SERVERS_1_PORT", Key: "servers.1.port", Value: "5433"},
+		{Name: "APP_TAGS", Key: "tags", Value: "a, b,c"},
+	}, c.(config.Inspector).EnvOverrides())
+
+	t.Setenv("APP__DB__DEBUG", "yes")
+	_, err = config.NewConfigOptions(
+		config.OptionString(config.ReaderTypeYAML, yml),
+		config.OptionENVPrefix("APP"),
+		config.OptionEnvOverlay("__"),
+	)
+	var pe *config.KeyParseError
+	testutils.Assert(t, errors.As(err, &pe), "invalid bool should return KeyParseError: %v", err)
+	testutils.Equals(t, "db.debug", pe.Key)
+}
+
+func TestFlags(t *testing.T) {
+	type dbConfig struct {
+		PoolSize int           `yaml:"pool-size" usage:"size of the pool"`
+		Timeout  time.Duration `yaml:"timeout" default:"5s"`
+		Hosts    []string      `yaml:"hosts"`
+		Debug    bool          `yaml:"debug"`
+	}
+	type appConfig struct {
+		Name string   `yaml:"name"`
+		DB   dbConfig `yaml:"db"`
+	}
+
+	t.Setenv("APP_DB_POOL_SIZE", "20")
+	yml := "name: demo\ndb:\n  pool-size: 10\n  hosts: [a]\n"
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.String("name", "flag default", "name of the app")
+	fs.Bool("verbose", false, "verbose output")
+	opts := []config.OptionFunc{
+		config.OptionString(config.ReaderTypeYAML, yml),
+		config.OptionENVPrefix("APP"),
+		config.OptionEnvOverlay(""),
+		config.OptionFlagSet(fs),
+	}
+
+	c, err := config.NewConfigOptions(opts...)
+	testutils.Ok(t, err)
+	testutils.Ok(t, config.DefineFlags(fs, c, &appConfig{}))
+	testutils.Equals(t, "20", fs.Lookup("db.pool-size").DefValue)
+	testutils.Equals(t, "size of the pool", fs.Lookup("db.pool-size").Usage)
+	testutils.Equals(t, "5s", fs.Lookup("db.timeout").DefValue)
+	testutils.Equals(t, "a", fs.Lookup("db.hosts").DefValue)
+	testutils.NotOk(t, config.DefineFlags(fs, c, "not a struct"))
+
+	testutils.Ok(t, fs.Parse([]string{"--db.pool-size=30", "--db.hosts=b,c", "--db.debug", "--verbose", "--name=app"}))
+	testutils.Ok(t, c.(config.Reloader).Reload())
+	testutils.Equals(t, "app", c.GetString("name"))
+	testutils.Equals(t, nil, c.GetInterface("verbose"))
+	testutils.Equals(t, "", c.(config.Inspector).LayerOf("verbose"))
+	testutils.Equals(t, 30, c.GetInt("db.pool-size"))
+	testutils.Equals(t, []string{"b", "c"}, c.GetStringList("db.hosts"))
+	testutils.Equals(t, true, c.GetBoolean("db.debug"))
+	testutils.Equals(t, config.LayerFlags, c.(config.Inspector).LayerOf("db.pool-size"))
+
+	testutils.NotOk(t, fs.Parse([]string{"--db.pool-size=many"}))
+
+	schema := []byte(`{"type": "object", "properties": {
+		"port": {"type": "integer", "description": "listen port", "default": 80},
+		"tags": {"type": "array", "items": {"type": "string"}}
+	}}`)
+	sfs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	testutils.Ok(t, config.DefineSchemaFlags(sfs, nil, schema))
+	testutils.Equals(t, "80", sfs.Lookup("port").DefValue)
+	testutils.Equals(t, "listen port", sfs.Lookup("port").Usage)
+	testutils.Ok(t, sfs.Parse([]string{"-port", "8080", "-tags", "x,y"}))
+	c, err = config.NewConfigOptions(config.OptionLayerOptions("defaults", config.Options{}), config.OptionFlagSet(sfs))
+	testutils.Ok(t, err)
+	testutils.Equals(t, 8080, c.GetInt("port"))
+	testutils.Equals(t, []string{"x", "y"}, c.GetStringList("tags"))
+}
+
+func TestDotEnv(t *testing.T) {
+	t.Setenv("DOTENV_TEST_HOME", "/home/app")
+	data := `# database
+export DB__HOST=localhost
+DB__PORT = 5432 # inline comment
+DB__URL="postgres://${DB__HOST}:${DB__PORT}/app"
+LITERAL='$DB__HOST is not expanded'
+CERT="line 1
+line 2"
+ESCAPED="a\tb\"c\""
+HOME_DIR=$DOTENV_TEST_HOME/data
+MISSING=${DOTENV_TEST_MISSING:-fallback}
+HOSTS__0=a
+HOSTS__1=b
+`
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeDotEnv, data))
+	testutils.Ok(t, err)
+	testutils.Equals(t, "localhost", c.GetString("DB.HOST"))
+	testutils.Equals(t, 5432, c.GetInt("DB.PORT"))
+	testutils.Equals(t, "postgres://localhost:5432/app", c.GetString("DB.URL"))
+	testutils.Equals(t, "$DB__HOST is not expanded", c.GetString("LITERAL"))
+	testutils.Equals(t, "line 1\nline 2", c.GetString("CERT"))
+	testutils.Equals(t, "a\tb\"c\"", c.GetString("ESCAPED"))
+	testutils.Equals(t, "/home/app/data", c.GetString("HOME_DIR"))
+	testutils.Equals(t, "fallback", c.GetString("MISSING"))
+	testutils.Equals(t, []string{"a", "b"}, c.GetStringList("HOSTS"))
+	db := c.GetValuesConfig("DB")
+	testutils.Assert(t, db != nil, "DB should be a config")
+	testutils.Equals(t, "localhost", db.GetString("HOST"))
+
+	pos, ok := c.(config.Inspector).Origin("DB.PORT")
+	testutils.Assert(t, ok, "DB.PORT should have a position")
+	testutils.Equals(t, config.Position{Line: 3, Column: 1}, pos)
+
+	bs, err := c.Dump()
+	testutils.Ok(t, err)
+	dumped, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeDotEnv, string(bs)))
+	testutils.Ok(t, err)
+	testutils.Equals(t, "a\tb\"c\"", dumped.GetString("ESCAPED"))
+	testutils.Equals(t, "$DB__HOST is not expanded", dumped.GetString("LITERAL"))
+	testutils.Equals(t, []string{"a", "b"}, dumped.GetStringList("HOSTS"))
+
+	c, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeDotEnv, "A.B=1\n"),
+		config.OptionReaderOptions(config.ReaderOptionDotEnvSeparator(".")))
+	testutils.Ok(t, err)
+	testutils.Equals(t, 1, c.GetInt("A.B"))
+
+	_, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeDotEnv, "A=\"unterminated\n"))
+	var pe *config.ParseError
+	testutils.Assert(t, errors.As(err, &pe), "unterminated value should return ParseError: %v", err)
+	testutils.Equals(t, 1, pe.Position.Line)
+
+	dir, err := ioutil.TempDir("", "config-dotenv")
+	testutils.Ok(t, err)
+	defer os.RemoveAll(dir)
+	envFile := filepath.Join(dir, ".env")
+	testutils.Ok(t, ioutil.WriteFile(envFile, []byte("DOTENV_TEST_USER=admin\nDOTENV_TEST_HOME=/ignored\n"), 0644))
+	c, err = config.NewConfigOptions(
+		config.OptionString(config.ReaderTypeYAML, "user: ${DOTENV_TEST_USER}\nhome: ${DOTENV_TEST_HOME}\n"),
+		config.OptionENVAllowed(),
+		config.OptionEnvFile(envFile),
+	)
+	testutils.Ok(t, err)
+	testutils.Equals(t, "admin", c.GetString("user"))
+	testutils.Equals(t, "/home/app", c.GetString("home"))
+
+	c, err = config.NewConfig(envFile)
+	testutils.Ok(t, err)
+	testutils.Equals(t, "admin", c.GetString("DOTENV_TEST_USER"))
+}
+
+func TestDotEnvNestedKeys(t *testing.T) {
+	// maps with keys 0..n-1 are lists, except the top level
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeDotEnv, "0=a\n1=b\nDB__0=x\nDB__2=y\nA=1\nA=2\n"))
+	testutils.Ok(t, err)
+	testutils.Equals(t, "a", c.GetString("0"))
+	testutils.Equals(t, "b", c.GetString("1"))
+	testutils.Equals(t, "y", c.GetString("DB.2"))
+	testutils.Equals(t, 2, c.GetInt("A"))
+
+	for _, data := range []string{"A=1\nA__B=2\n", "A__B=2\nA=1\n"} {
+		_, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeDotEnv, data))
+		var pe *config.ParseError
+		testutils.Assert(t, errors.As(err, &pe), "conflicting keys should return ParseError: %v", err)
+		testutils.Equals(t, 2, pe.Position.Line)
+	}
+}
+
+func TestPropertiesAndINI(t *testing.T) {
+	properties := `# server
+server.host = localhost
+server.port:8080
+! another comment
+server.name\ with\ spaces=demo
+greeting=\u4f60\u597d, \
+    world
+path=C:\\app\\data
+servers.0=a
+servers.1=b
+empty
+`
+	c, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeProperties, properties))
+	testutils.Ok(t, err)
+	testutils.Equals(t, "localhost", c.GetString("server.host"))
+	testutils.Equals(t, 8080, c.GetInt("server.port"))
+	testutils.Equals(t, "demo", c.GetString(`server."name with spaces"`))
+	testutils.Equals(t, "你好, world", c.GetString("greeting"))
+	testutils.Equals(t, `C:\app\data`, c.GetString("path"))
+	testutils.Equals(t, []string{"a", "b"}, c.GetStringList("servers"))
+	testutils.Equals(t, "", c.GetString("empty", "default"))
+	server := c.GetValuesConfig("server")
+	testutils.Assert(t, server != nil, "server should be a config")
+	testutils.Equals(t, 8080, server.GetInt("port"))
+	pos, ok := c.(config.Inspector).Origin("server.port")
+	testutils.Assert(t, ok, "server.port should have a position")
+	testutils.Equals(t, config.Position{Line: 3, Column: 1}, pos)
+
+	bs, err := c.Dump()
+	testutils.Ok(t, err)
+	dumped, err := config.NewConfigOptions(config.OptionString(config.ReaderTypeProperties, string(bs)))
+	testutils.Ok(t, err)
+	testutils.Equals(t, "demo", dumped.GetString(`server."name with spaces"`))
+	testutils.Equals(t, "你好, world", dumped.GetString("greeting"))
+	testutils.Equals(t, []string{"a", "b"}, dumped.GetStringList("servers"))
+
+	_, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeProperties, "a=1\na.b=2\n"))
+	var pe *config.ParseError
+	testutils.Assert(t, errors.As(err, &pe), "conflicting keys should return ParseError: %v", err)
+	testutils.Equals(t, 2, pe.Position.Line)
+
+	ini := `; global
+name = demo
+
+[db]
+host = localhost ; inline comment
+port: 5432
+password = "p;ss#word"
+
+[db.replica]
+hosts[] = r1
+hosts[] = r2
+
+[empty]
+`
+	c, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeINI, ini))
+	testutils.Ok(t, err)
+	testutils.Equals(t, "demo", c.GetString("name"))
+	testutils.Equals(t, "localhost", c.GetString("db.host"))
+	testutils.Equals(t, 5432, c.GetInt("db.port"))
+	testutils.Equals(t, "p;ss#word", c.GetString("db.password"))
+	testutils.Equals(t, []string{"r1", "r2"}, c.GetStringList("db.replica.hosts"))
+	testutils.Equals(t, config.Options{}, c.GetMap("empty"))
+	db := c.GetValuesConfig("db")
+	testutils.Assert(t, db != nil, "db should be a config")
+	testutils.Equals(t, "localhost", db.GetString("host"))
+
+	bs, err = c.Dump()
+	testutils.Ok(t, err)
+	testutils.Equals(t, `name = demo
+
+[db]
+host = localhost
+password = "p;ss#word"
+port = 5432
+
+[db.replica]
+hosts[] = r1
+hosts[] = r2
+
+[empty]
+`, string(bs))
+
+	_, err = config.NewConfigOptions(config.OptionString(config.ReaderTypeINI, "[db\nhost=x\n"))
+	testutils.Assert(t, errors.As(err, &pe), "unclosed section should return ParseError: %v", err)
+	testutils.Equals(t, 1, pe.Position.Line)
+
+	dir, err := ioutil.TempDir("", "config-ini")
+	testutils.Ok(t, err)
+	defer os.RemoveAll(dir)
+	for name, data := range map[string]string{"app.ini": ini, "app.properties": properties} {
+		f := filepath.Join(dir, name)
+		testutils.Ok(t, ioutil.WriteFile(f, []byte(data), 0644))
+		c, err = config.NewConfig(f)
+		testutils.Ok(t, err)
+		testutils.Assert(t, c.GetString("name") == "demo" || c.GetString("server.host") == "localhost", "%s is not parsed", name)
+	}
+}
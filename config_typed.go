@@ -0,0 +1,162 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// GetE return the key's value decoded into T,
+// T may be any type which implements encoding.TextUnmarshaler
+func GetE[T any](c Config, key string) (T, error) {
+	var t T
+	v, err := getInterfaceE(c, key)
+	if err != nil {
+		return t, err
+	}
+	if err = decodeValue(key, v, reflect.ValueOf(&t).Elem()); err != nil {
+		var zero T
+		return zero, err
+	}
+	return t, nil
+}
+
+// Get return the key's value decoded into T, or the default value if failed
+func Get[T any](c Config, key string, defValue ...T) T {
+	t, err := GetE[T](c, key)
+	if err != nil && len(defValue) > 0 {
+		return defValue[0]
+	}
+	return t
+}
+
+// KeyInfo the description of a registered typed key
+type KeyInfo struct {
+	Name        string
+	Type        string
+	Default     interface{}
+	Description string
+}
+
+// TypedKey a reusable handle of a key with type T
+type TypedKey[T any] struct {
+	locker      sync.RWMutex
+	name        string
+	def         T
+	hasDefault  bool
+	description string
+}
+
+type keyDescriber interface {
+	info() KeyInfo
+}
+
+var (
+	keysLocker sync.Mutex
+	keys       = make(map[string]keyDescriber)
+)
+
+// Key return a typed handle of the key, call Register to list it in RegisteredKeys
+func Key[T any](name string) *TypedKey[T] {
+	return &TypedKey[T]{name: name}
+}
+
+// RegisteredKeys return all keys registered by Register, sorted by name
+func RegisteredKeys() []KeyInfo {
+	keysLocker.Lock()
+	infos := make([]KeyInfo, 0, len(keys))
+	for _, k := range keys {
+		infos = append(infos, k.info())
+	}
+	keysLocker.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Register register the key for RegisteredKeys, it replaces the registered key with the same name
+func (p *TypedKey[T]) Register() *TypedKey[T] {
+	keysLocker.Lock()
+	keys[p.name] = p
+	keysLocker.Unlock()
+	return p
+}
+
+// Default set the value returned when the key is not found
+func (p *TypedKey[T]) Default(v T) *TypedKey[T] {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.def, p.hasDefault = v, true
+	return p
+}
+
+// Description set the key's description for documentation
+func (p *TypedKey[T]) Description(s string) *TypedKey[T] {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.description = s
+	return p
+}
+
+// Name return the key's name
+func (p *TypedKey[T]) Name() string {
+	return p.name
+}
+
+// GetE return the key's value, or the default value if the key is not found
+func (p *TypedKey[T]) GetE(c Config) (T, error) {
+	t, err := GetE[T](c, p.name)
+	if err == nil {
+		return t, nil
+	}
+
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+	if _, ok := err.(*KeyNotFoundError); ok && p.hasDefault {
+		return p.def, nil
+	}
+	return t, err
+}
+
+// Get return the key's value, or the default value if failed
+func (p *TypedKey[T]) Get(c Config) T {
+	t, err := p.GetE(c)
+	if err != nil {
+		p.locker.RLock()
+		defer p.locker.RUnlock()
+		return p.def
+	}
+	return t
+}
+
+func (p *TypedKey[T]) info() KeyInfo {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
+	info := KeyInfo{
+		Name:        p.name,
+		Type:        reflect.TypeOf((*T)(nil)).Elem().String(),
+		Description: p.description,
+	}
+	if p.hasDefault {
+		info.Default = p.def
+	}
+	return info
+}
@@ -0,0 +1,243 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Validate check the keys' values with rules like "required,min=1,max=65535,oneof=debug info",
+// return ValidationError with all violations
+func (p *AdapterConfig) Validate(rules map[string]string) error {
+	keys := make([]string, 0, len(rules))
+	for k := range rules {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ve := &ValidationError{}
+	for _, key := range keys {
+		v, err := p.GetInterfaceE(key)
+		if err != nil {
+			var nf *KeyNotFoundError
+			if !errors.As(err, &nf) {
+				ve.Violations = append(ve.Violations, Violation{Key: key, Rule: "type", Message: err.Error()})
+				continue
+			}
+			v = nil
+		}
+		ve.Violations = append(ve.Violations, validateValue(key, rules[key], rawValue(v), v != nil)...)
+	}
+
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+	return annotateError(p.layers, p.runtime, ve.orNil())
+}
+
+// rawString a string value of configs, which is validated as a number by min and max if it's numeric
+type rawString string
+
+// rawValue return the value to validate, strings are rawStrings, json numbers are int64 or float64
+func rawValue(v interface{}) reflect.Value {
+	switch t := v.(type) {
+	case string:
+		return reflect.ValueOf(rawString(t))
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return reflect.ValueOf(i)
+		}
+		if f, err := t.Float64(); err == nil {
+			return reflect.ValueOf(f)
+		}
+		return reflect.ValueOf(rawString(t))
+	}
+	return reflect.ValueOf(v)
+}
+
+// rawNumber return the number of a numeric rawString, NaN and infinities are not numbers
+func rawNumber(rv reflect.Value) (float64, bool) {
+	s, ok := rv.Interface().(rawString)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(string(s)), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// collectViolations append the violations of err into ve, return err if it's not a ValidationError
+func collectViolations(ve *ValidationError, err error) error {
+	var e *ValidationError
+	if errors.As(err, &e) {
+		ve.Violations = append(ve.Violations, e.Violations...)
+		return nil
+	}
+	return err
+}
+
+// validateValue check the value with rules, present means the key is set or has a default
+func validateValue(key, rules string, rv reflect.Value, present bool) []Violation {
+	if strings.TrimSpace(rules) == "" {
+		return nil
+	}
+
+	var violations []Violation
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg := strings.TrimSpace(rule), ""
+		if i := strings.Index(name, "="); i >= 0 {
+			name, arg = name[:i], name[i+1:]
+		}
+		if name == "" {
+			continue
+		}
+
+		if name == "required" {
+			if !present {
+				violations = append(violations, Violation{Key: key, Rule: name, Message: "is required"})
+			}
+			continue
+		}
+		if !present {
+			continue
+		}
+
+		for rv.IsValid() && (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) {
+			if rv.IsNil() {
+				break
+			}
+			rv = rv.Elem()
+		}
+		if !rv.IsValid() || ((rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) && rv.IsNil()) {
+			continue
+		}
+
+		if msg := checkRule(name, arg, rv); msg != "" {
+			violations = append(violations, Violation{Key: key, Rule: name, Message: msg})
+		}
+	}
+	return violations
+}
+
+// checkRule return the message if the value violates the rule
+func checkRule(name, arg string, rv reflect.Value) string {
+	switch name {
+	case "min", "max":
+		if f, ok := rawNumber(rv); ok {
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return fmt.Sprintf("invalid rule %s=%s", name, arg)
+			}
+			if name == "min" && f < limit {
+				return fmt.Sprintf("%v is less than %s", rv.Interface(), arg)
+			}
+			if name == "max" && f > limit {
+				return fmt.Sprintf("%v is greater than %s", rv.Interface(), arg)
+			}
+			return ""
+		}
+		if n, ok := ruleLength(rv); ok {
+			limit, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Sprintf("invalid rule %s=%s", name, arg)
+			}
+			if name == "min" && n < limit {
+				return fmt.Sprintf("length %d is less than %d", n, limit)
+			}
+			if name == "max" && n > limit {
+				return fmt.Sprintf("length %d is greater than %d", n, limit)
+			}
+			return ""
+		}
+
+		f, ok := ruleNumber(rv)
+		if !ok {
+			return fmt.Sprintf("%s is not supported by %s", name, rv.Type())
+		}
+		limit, err := ruleLimit(arg, rv.Type())
+		if err != nil {
+			return fmt.Sprintf("invalid rule %s=%s", name, arg)
+		}
+		if name == "min" && f < limit {
+			return fmt.Sprintf("%v is less than %s", rv.Interface(), arg)
+		}
+		if name == "max" && f > limit {
+			return fmt.Sprintf("%v is greater than %s", rv.Interface(), arg)
+		}
+	case "len":
+		n, ok := ruleLength(rv)
+		if !ok {
+			return fmt.Sprintf("len is not supported by %s", rv.Type())
+		}
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Sprintf("invalid rule len=%s", arg)
+		}
+		if n != limit {
+			return fmt.Sprintf("length %d is not %d", n, limit)
+		}
+	case "oneof":
+		s := fmt.Sprint(rv.Interface())
+		for _, o := range strings.Fields(arg) {
+			if s == o {
+				return ""
+			}
+		}
+		return fmt.Sprintf("%s is not one of [%s]", s, arg)
+	default:
+		return fmt.Sprintf("unknown rule %s", name)
+	}
+	return ""
+}
+
+func ruleLength(rv reflect.Value) (int, bool) {
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len(), true
+	}
+	return 0, false
+}
+
+func ruleNumber(rv reflect.Value) (float64, bool) {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// ruleLimit parse the rule's argument, durations can be like 1s
+func ruleLimit(arg string, t reflect.Type) (float64, error) {
+	if t == typeDuration {
+		d, err := convertDuration(arg)
+		return float64(d), err
+	}
+	return strconv.ParseFloat(arg, 64)
+}
@@ -0,0 +1,209 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fileStamp the state of a watched file, a changed sum is pending until it's the same in the next poll,
+// so that a file being written isn't reloaded
+type fileStamp struct {
+	sum     [sha256.Size]byte
+	pending *[sha256.Size]byte
+}
+
+// update set the sum of the current poll, return true if the sum is changed and kept for two polls
+func (p *fileStamp) update(sum [sha256.Size]byte) bool {
+	if sum == p.sum {
+		p.pending = nil
+		return false
+	}
+	if p.pending == nil || *p.pending != sum {
+		p.pending = &sum
+		return false
+	}
+	p.sum, p.pending = sum, nil
+	return true
+}
+
+// Reload read all sources again, and replace configs if succeed,
+// or keep the last configs and return the error
+func (p *AdapterConfig) Reload() error {
+	p.locker.RLock()
+	sources, opts := p.sources, p.readerOptions
+	p.locker.RUnlock()
+
+	if len(sources) == 0 {
+		return nil
+	}
+
+	dotEnv, err := loadDotEnv(p.envFiles)
+	if err != nil {
+		return err
+	}
+	layers, err := loadLayers(sources, opts)
+	if err != nil {
+		return err
+	}
+	if layers, err = p.appendEnvLayer(layers); err != nil {
+		return err
+	}
+	if layers, err = p.appendFlagLayer(layers); err != nil {
+		return err
+	}
+
+	changes, err := p.swap(layers, dotEnv)
+	if err != nil {
+		return err
+	}
+	p.notify(changes)
+	return nil
+}
+
+// swap build configs with layers and variables of .env files, and replace the current ones,
+// return the changes if anyone subscribes them
+func (p *AdapterConfig) swap(layers []*configLayer, dotEnv map[string]string) ([]KeyChange, error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	last := p.dotEnv
+	p.dotEnv = dotEnv
+	configs, err := p.build(layers)
+	if err != nil {
+		p.dotEnv = last
+		return nil, err
+	}
+
+	var changes []KeyChange
+	if p.hasSubscribers() {
+		changes = diffConfigs(p.configs, configs)
+	}
+
+	primary := primaryLayer(layers)
+	p.readerType, p.reader, p.data = primary.readerType, primary.reader, primary.data
+	p.layers, p.configs = layers, configs
+	return changes, nil
+}
+
+// StopWatch stop watching the config files
+func (p *AdapterConfig) StopWatch() {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.watchStop != nil {
+		close(p.watchStop)
+		p.watchStop = nil
+	}
+}
+
+func (p *AdapterConfig) startWatch() {
+	stamps := make(map[string]*fileStamp)
+	for _, l := range p.layers {
+		for _, name := range l.watchFiles() {
+			data := l.data
+			if name != l.file {
+				data, _ = ioutil.ReadFile(name)
+			}
+			stamps[name] = &fileStamp{sum: sha256.Sum256(data)}
+		}
+	}
+	for _, name := range p.envFiles {
+		data, _ := ioutil.ReadFile(name)
+		stamps[name] = &fileStamp{sum: sha256.Sum256(data)}
+	}
+	for _, s := range p.sources {
+		if len(s.dir) > 0 {
+			files, _ := dirFiles(s.dir, s.glob)
+			stamps[dirStampKey(s)] = &fileStamp{sum: sha256.Sum256([]byte(strings.Join(files, "\n")))}
+		}
+	}
+
+	p.watchStop = make(chan struct{})
+	go p.watch(p.watchInterval, p.watchStop, stamps)
+}
+
+func (p *AdapterConfig) watch(interval time.Duration, stop chan struct{}, stamps map[string]*fileStamp) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !p.filesChanged(stamps) {
+			continue
+		}
+
+		if err := p.Reload(); err != nil && p.watchErrorHandler != nil {
+			p.watchErrorHandler(err)
+		}
+	}
+}
+
+// dirStampKey return the stamp's key of a directory source's files
+func dirStampKey(s *configLayer) string {
+	return "dir:" + filepath.Join(s.dir, s.glob)
+}
+
+// filesChanged judge if any watched file's content is changed,
+// or files are added into or removed from the watched directories, missing files have the sum of no data
+func (p *AdapterConfig) filesChanged(stamps map[string]*fileStamp) (changed bool) {
+	p.locker.RLock()
+	layers, sources := p.layers, p.sources
+	p.locker.RUnlock()
+
+	for _, s := range sources {
+		if len(s.dir) == 0 {
+			continue
+		}
+		files, _ := dirFiles(s.dir, s.glob)
+		if stampChanged(stamps, dirStampKey(s), []byte(strings.Join(files, "\n"))) {
+			changed = true
+		}
+	}
+
+	names := append([]string{}, p.envFiles...)
+	for _, l := range layers {
+		names = append(names, l.watchFiles()...)
+	}
+	for _, name := range names {
+		data, _ := ioutil.ReadFile(name)
+		if stampChanged(stamps, name, data) {
+			changed = true
+		}
+	}
+	return
+}
+
+// stampChanged update the stamp of the key with data, a new key is not changed
+func stampChanged(stamps map[string]*fileStamp, key string, data []byte) bool {
+	sum := sha256.Sum256(data)
+	stamp, ok := stamps[key]
+	if !ok {
+		stamps[key] = &fileStamp{sum: sum}
+		return false
+	}
+	return stamp.update(sum)
+}
@@ -0,0 +1,453 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iTrellis/common/errors"
+	"github.com/iTrellis/common/formats"
+)
+
+var (
+	// errTypeMismatch the value's type can't be converted
+	errTypeMismatch = errors.New("type mismatch")
+	errOutOfRange   = strconv.ErrRange
+)
+
+// convertString convert scalar values to string
+func convertString(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case []byte:
+		return string(t), nil
+	case json.Number:
+		return t.String(), nil
+	case time.Time:
+		return t.Format(time.RFC3339Nano), nil
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, time.Duration:
+		return fmt.Sprint(t), nil
+	}
+	return "", errTypeMismatch
+}
+
+// convertInt64 convert numbers and numeric strings to int64
+func convertInt64(v interface{}) (int64, error) {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return 0, err
+		}
+		return floatToInt64(f)
+	case string:
+		s := strings.TrimSpace(t)
+		if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, err
+		}
+		return floatToInt64(f)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return 0, strconv.ErrRange
+		}
+		return int64(u), nil
+	case reflect.Float32, reflect.Float64:
+		return floatToInt64(rv.Float())
+	}
+	return 0, errTypeMismatch
+}
+
+func floatToInt64(f float64) (int64, error) {
+	if f != math.Trunc(f) {
+		return 0, errors.Newf("%v is not an integer", f)
+	}
+	if f > math.MaxInt64 || f < math.MinInt64 {
+		return 0, strconv.ErrRange
+	}
+	return int64(f), nil
+}
+
+// convertUint64 convert non-negative numbers and numeric strings to uint64
+func convertUint64(v interface{}) (uint64, error) {
+	if s, ok := v.(string); ok {
+		if u, err := strconv.ParseUint(strings.TrimSpace(s), 0, 64); err == nil {
+			return u, nil
+		}
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint(), nil
+	}
+	i, err := convertInt64(v)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		return 0, strconv.ErrRange
+	}
+	return uint64(i), nil
+}
+
+// convertFloat64 convert numbers and numeric strings to float64
+func convertFloat64(v interface{}) (float64, error) {
+	switch t := v.(type) {
+	case json.Number:
+		return t.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(t), 64)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	}
+	return 0, errTypeMismatch
+}
+
+// convertBool convert bools and strings like on, off, true, false, yes, no, 1, 0 to bool
+func convertBool(v interface{}) (bool, error) {
+	switch t := v.(type) {
+	case bool:
+		return t, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(t)) {
+		case "on", "true", "yes", "y", "1":
+			return true, nil
+		case "off", "false", "no", "n", "0":
+			return false, nil
+		}
+		return false, errors.Newf("invalid boolean: %q", t)
+	}
+	return false, errTypeMismatch
+}
+
+// convertDuration convert durations, time of days, and strings like 1s, 1day, 1h30m to time.Duration
+func convertDuration(v interface{}) (time.Duration, error) {
+	switch t := v.(type) {
+	case time.Duration:
+		return t, nil
+	case time.Time:
+		// time of day without date, exp: toml's local time 07:32:00
+		if t.Year() == 0 {
+			return t.Sub(time.Date(0, 1, 1, 0, 0, 0, 0, t.Location())), nil
+		}
+	case string:
+		s := strings.ToLower(strings.TrimSpace(t))
+		if d, err := time.ParseDuration(s); err == nil {
+			return d, nil
+		}
+		const invalid = time.Duration(math.MinInt64)
+		if d := formats.ParseStringTime(s, invalid); d != invalid {
+			return d, nil
+		}
+		return 0, errors.Newf("invalid duration: %q", t)
+	}
+	return 0, errTypeMismatch
+}
+
+// convertTime convert times and strings in RFC3339, datetime, date or time layouts to time.Time
+func convertTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		return parseTimeString(strings.TrimSpace(t))
+	}
+	return time.Time{}, errTypeMismatch
+}
+
+// timeLayouts layouts of time strings, exp: RFC3339, datetime, date or time
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+	"15:04:05.999999999",
+}
+
+// parseTimeString parse the string by timeLayouts in order
+func parseTimeString(s string) (t time.Time, err error) {
+	for _, layout := range timeLayouts {
+		if t, err = time.Parse(layout, s); err == nil {
+			return
+		}
+	}
+	return
+}
+
+// convertByteSize convert numbers and strings like 1k, 10mb to bytes
+func convertByteSize(v interface{}) (*big.Int, error) {
+	switch t := v.(type) {
+	case *big.Int:
+		return t, nil
+	case string:
+		s := strings.ToLower(strings.TrimSpace(t))
+		if i, ok := new(big.Int).SetString(s, 10); ok {
+			return i, nil
+		}
+		if size := formats.ParseStringByteSize(s); size != nil {
+			return size, nil
+		}
+		return nil, errors.Newf("invalid byte size: %q", t)
+	}
+	i, err := convertInt64(v)
+	if err != nil {
+		return nil, err
+	}
+	return big.NewInt(i), nil
+}
+
+// convertList convert slices and arrays to []interface{}
+func convertList(v interface{}) ([]interface{}, error) {
+	if l, ok := v.([]interface{}); ok {
+		return l, nil
+	}
+	rv := reflect.ValueOf(v)
+	if !isList(rv) {
+		return nil, errTypeMismatch
+	}
+	l := make([]interface{}, rv.Len())
+	for i := range l {
+		l[i] = rv.Index(i).Interface()
+	}
+	return l, nil
+}
+
+// convertMap convert maps to Options
+func convertMap(v interface{}) (Options, error) {
+	m, ok := toStringMap(v)
+	if !ok {
+		return nil, errTypeMismatch
+	}
+	return m, nil
+}
+
+// keyError return the typed error of key's value
+func keyError(key string, v interface{}, expected string, err error) error {
+	if err == errTypeMismatch {
+		return &KeyTypeError{Key: key, Expected: expected, Value: v}
+	}
+	return &KeyParseError{Key: key, Value: v, Err: err}
+}
+
+var (
+	typeDuration        = reflect.TypeOf(time.Duration(0))
+	typeTime            = reflect.TypeOf(time.Time{})
+	typeBigInt          = reflect.TypeOf(big.Int{})
+	typeTextUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// decodeValue decode value v into rv, which must be settable, structs of nil values get their defaults,
+// errors are KeyTypeError or KeyParseError with the key path of v
+func decodeValue(key string, v interface{}, rv reflect.Value) error {
+	if v == nil {
+		if rv.Kind() == reflect.Struct && isPlainStruct(rv) {
+			return bindStruct(key, nil, rv)
+		}
+		return nil
+	}
+
+	if rv.Kind() == reflect.Ptr {
+		if !rv.IsNil() {
+			return decodeValue(key, v, rv.Elem())
+		}
+		elem := reflect.New(rv.Type().Elem())
+		if err := decodeValue(key, v, elem.Elem()); err != nil {
+			return err
+		}
+		rv.Set(elem)
+		return nil
+	}
+
+	if rv.Kind() == reflect.Interface {
+		if !reflect.TypeOf(v).AssignableTo(rv.Type()) {
+			return keyError(key, v, rv.Type().String(), errTypeMismatch)
+		}
+		rv.Set(reflect.ValueOf(v))
+		return nil
+	}
+
+	if vv := reflect.ValueOf(v); vv.Type().AssignableTo(rv.Type()) {
+		rv.Set(vv)
+		return nil
+	}
+
+	switch rv.Type() {
+	case typeDuration:
+		d, err := convertDuration(v)
+		if err != nil {
+			return keyError(key, v, "duration", err)
+		}
+		rv.SetInt(int64(d))
+		return nil
+	case typeTime:
+		t, err := convertTime(v)
+		if err != nil {
+			return keyError(key, v, "time", err)
+		}
+		rv.Set(reflect.ValueOf(t))
+		return nil
+	case typeBigInt:
+		i, err := convertByteSize(v)
+		if err != nil {
+			return keyError(key, v, "big int", err)
+		}
+		rv.Set(reflect.ValueOf(*i))
+		return nil
+	}
+
+	if rv.CanAddr() && rv.Addr().Type().Implements(typeTextUnmarshaler) {
+		s, err := convertString(v)
+		if err != nil {
+			return keyError(key, v, rv.Type().String(), err)
+		}
+		if err = rv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return keyError(key, v, rv.Type().String(), err)
+		}
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		s, err := convertString(v)
+		if err != nil {
+			return keyError(key, v, "string", err)
+		}
+		rv.SetString(s)
+	case reflect.Bool:
+		b, err := convertBool(v)
+		if err != nil {
+			return keyError(key, v, "bool", err)
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := convertInt64(v)
+		if err == nil && rv.OverflowInt(i) {
+			err = errOutOfRange
+		}
+		if err != nil {
+			return keyError(key, v, rv.Type().String(), err)
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := convertUint64(v)
+		if err == nil && rv.OverflowUint(u) {
+			err = errOutOfRange
+		}
+		if err != nil {
+			return keyError(key, v, rv.Type().String(), err)
+		}
+		rv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := convertFloat64(v)
+		if err == nil && rv.OverflowFloat(f) {
+			err = errOutOfRange
+		}
+		if err != nil {
+			return keyError(key, v, rv.Type().String(), err)
+		}
+		rv.SetFloat(f)
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			s, err := convertString(v)
+			if err != nil {
+				return keyError(key, v, "bytes", err)
+			}
+			rv.SetBytes([]byte(s))
+			return nil
+		}
+		l, err := convertList(v)
+		if err != nil {
+			return keyError(key, v, rv.Type().String(), err)
+		}
+		s := reflect.MakeSlice(rv.Type(), len(l), len(l))
+		ve := &ValidationError{}
+		for i, item := range l {
+			if err := collectViolations(ve, decodeValue(childKey(key, strconv.Itoa(i)), item, s.Index(i))); err != nil {
+				return err
+			}
+		}
+		rv.Set(s)
+		return ve.orNil()
+	case reflect.Array:
+		l, err := convertList(v)
+		if err != nil {
+			return keyError(key, v, rv.Type().String(), err)
+		}
+		if len(l) > rv.Len() {
+			return keyError(key, v, rv.Type().String(), errOutOfRange)
+		}
+		ve := &ValidationError{}
+		for i, item := range l {
+			if err := collectViolations(ve, decodeValue(childKey(key, strconv.Itoa(i)), item, rv.Index(i))); err != nil {
+				return err
+			}
+		}
+		return ve.orNil()
+	case reflect.Map:
+		m, ok := toStringMap(v)
+		if !ok || rv.Type().Key().Kind() != reflect.String {
+			return keyError(key, v, rv.Type().String(), errTypeMismatch)
+		}
+		result := reflect.MakeMapWithSize(rv.Type(), len(m))
+		ve := &ValidationError{}
+		for _, k := range sortedKeys(m) {
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			if err := collectViolations(ve, decodeValue(childKey(key, k), m[k], elem)); err != nil {
+				return err
+			}
+			result.SetMapIndex(reflect.ValueOf(k).Convert(rv.Type().Key()), elem)
+		}
+		rv.Set(result)
+		return ve.orNil()
+	case reflect.Struct:
+		return bindStruct(key, v, rv)
+	default:
+		return keyError(key, v, rv.Type().String(), errTypeMismatch)
+	}
+	return nil
+}
@@ -17,13 +17,20 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 
 package config
 
+import "sort"
+
 // DeepCopy 深度拷贝
 func DeepCopy(value interface{}) interface{} {
 	switch valueType := value.(type) {
-	case map[string]interface{}, Options:
+	case map[string]interface{}:
+		newMap := make(map[string]interface{})
+		for k, v := range valueType {
+			newMap[k] = DeepCopy(v)
+		}
+		return newMap
+	case Options:
 		newMap := make(map[string]interface{})
-		valueMap := valueType.(map[string]interface{})
-		for k, v := range valueMap {
+		for k, v := range valueType {
 			newMap[k] = DeepCopy(v)
 		}
 		return newMap
@@ -43,3 +50,52 @@ func DeepCopy(value interface{}) interface{} {
 
 	return value
 }
+
+// deepMerge 深度合并, src中的值覆盖dst中的值, 两者都是map时递归合并
+func deepMerge(dst, src map[string]interface{}) {
+	for k, sv := range src {
+		sm, ok := toStringMap(sv)
+		if !ok {
+			dst[k] = DeepCopy(sv)
+			continue
+		}
+		dm, ok := toStringMap(dst[k])
+		if !ok {
+			dm = make(map[string]interface{})
+		}
+		deepMerge(dm, sm)
+		dst[k] = dm
+	}
+}
+
+// toStringMap convert map types into map[string]interface{}
+func toStringMap(value interface{}) (map[string]interface{}, bool) {
+	switch t := value.(type) {
+	case map[string]interface{}:
+		return t, true
+	case Options:
+		return t, true
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{})
+		for k, v := range t {
+			sk, ok := k.(string)
+			if !ok {
+				continue
+			}
+			result[sk] = v
+		}
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+// sortedKeys return the map's keys in order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -18,6 +18,9 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package config
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/iTrellis/common/errors"
 )
 
@@ -28,4 +31,179 @@ var (
 	ErrInvalidFilePath        = errors.New("invalid file path")
 	ErrUnknownSuffixes        = errors.New("unknown file with suffix")
 	ErrNotSupportedReaderType = errors.New("not supported reader type")
+	ErrUnclosedReference      = errors.New("unclosed reference ${")
+	ErrIndexOutOfRange        = errors.New("list index out of range")
+	ErrNotPointer             = errors.New("object is not a non-nil pointer")
+	ErrNoConfigFile           = errors.New("no config file to save")
+	ErrFileChanged            = errors.New("config file is changed since loaded")
+	ErrIncludeCycle           = errors.New("include cycle")
+	ErrNotStruct              = errors.New("object is not a struct")
+	ErrIncludesNotSaved       = errors.New("config with includes can't be saved by dumping")
+	ErrIncludeNotEditable     = errors.New("include directive can't be edited")
 )
+
+// InterpolationError error of replacing references like ${X.Y.Z} in key's value
+type InterpolationError struct {
+	Key      string
+	Value    string
+	Err      error
+	Position Position
+}
+
+func (p *InterpolationError) Error() string {
+	if p.Position.IsValid() {
+		return fmt.Sprintf("%s: interpolate %s: %q: %s", p.Position, p.Key, p.Value, p.Err)
+	}
+	return fmt.Sprintf("interpolate %s: %q: %s", p.Key, p.Value, p.Err)
+}
+
+// Unwrap return the cause
+func (p *InterpolationError) Unwrap() error {
+	return p.Err
+}
+
+// KeyNotFoundError error of the key which is not found
+type KeyNotFoundError struct {
+	Key string
+}
+
+func (p *KeyNotFoundError) Error() string {
+	return fmt.Sprintf("key %s: not found", p.Key)
+}
+
+// KeyTypeError error of the key's value which has a wrong type
+type KeyTypeError struct {
+	Key      string
+	Expected string
+	Value    interface{}
+}
+
+func (p *KeyTypeError) Error() string {
+	return fmt.Sprintf("key %s: expected %s, got %T", p.Key, p.Expected, p.Value)
+}
+
+// KeyParseError error of the key's value which can't be parsed
+type KeyParseError struct {
+	Key   string
+	Value interface{}
+	Err   error
+}
+
+func (p *KeyParseError) Error() string {
+	return fmt.Sprintf("key %s: parse %v: %s", p.Key, p.Value, p.Err)
+}
+
+// Unwrap return the cause
+func (p *KeyParseError) Unwrap() error {
+	return p.Err
+}
+
+// ReferenceCycleError error of references which refer to each other
+type ReferenceCycleError struct {
+	Path []string
+}
+
+func (p *ReferenceCycleError) Error() string {
+	return "reference cycle: " + strings.Join(p.Path, " -> ")
+}
+
+// Violation a key's value which violates the rule
+type Violation struct {
+	Key      string
+	Rule     string
+	Message  string
+	Position Position
+}
+
+func (p Violation) String() string {
+	if p.Position.IsValid() {
+		return fmt.Sprintf("%s: %s: %s", p.Position, p.Key, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Key, p.Message)
+}
+
+// ValidationError error of all violations in validation
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (p *ValidationError) Error() string {
+	msgs := make([]string, 0, len(p.Violations))
+	for _, v := range p.Violations {
+		msgs = append(msgs, v.String())
+	}
+	return fmt.Sprintf("validate: %d violation(s): %s", len(p.Violations), strings.Join(msgs, "; "))
+}
+
+func (p *ValidationError) orNil() error {
+	if len(p.Violations) == 0 {
+		return nil
+	}
+	return p
+}
+
+// UnknownKey a key which is not mapped to any field, with the most similar known key
+type UnknownKey struct {
+	Key        string
+	Suggestion string
+}
+
+func (p UnknownKey) String() string {
+	if p.Suggestion == "" {
+		return p.Key
+	}
+	return fmt.Sprintf("%s (did you mean %s?)", p.Key, p.Suggestion)
+}
+
+// UnknownKeysError error of the unknown keys in strict mode
+type UnknownKeysError struct {
+	Keys []UnknownKey
+}
+
+func (p *UnknownKeysError) Error() string {
+	keys := make([]string, 0, len(p.Keys))
+	for _, k := range p.Keys {
+		keys = append(keys, k.String())
+	}
+	return "unknown keys: " + strings.Join(keys, ", ")
+}
+
+func (p *UnknownKeysError) orNil() error {
+	if len(p.Keys) == 0 {
+		return nil
+	}
+	return p
+}
+
+// ParseError error of parsing data, with the position if it's known
+type ParseError struct {
+	Position Position
+	Err      error
+}
+
+func (p *ParseError) Error() string {
+	if s := p.Position.String(); s != "" {
+		return fmt.Sprintf("%s: %s", s, p.Err)
+	}
+	return p.Err.Error()
+}
+
+// Unwrap return the cause
+func (p *ParseError) Unwrap() error {
+	return p.Err
+}
+
+// IncludeError error of resolving include directives, with the chain of including files
+type IncludeError struct {
+	Chain []string
+	Err   error
+}
+
+func (p *IncludeError) Error() string {
+	return fmt.Sprintf("include %s: %s", strings.Join(p.Chain, " -> "), p.Err)
+}
+
+// Unwrap return the cause
+func (p *IncludeError) Unwrap() error {
+	return p.Err
+}
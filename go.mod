@@ -1,8 +1,17 @@
 module github.com/iTrellis/config
 
-go 1.13
+go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/iTrellis/common v0.21.15
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
+
+require (
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/json-iterator/go v1.1.11 // indirect
+	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
+	github.com/modern-go/reflect2 v1.0.1 // indirect
+	golang.org/x/text v0.3.7 // indirect
+)
@@ -32,6 +32,16 @@ func (p *Options) ToConfig(rts ...ReaderType) Config {
 		c.reader = NewJSONReader()
 	case ReaderTypeYAML:
 		c.reader = NewYAMLReader()
+	case ReaderTypeXML:
+		c.reader = NewXMLReader()
+	case ReaderTypeTOML:
+		c.reader = NewTOMLReader()
+	case ReaderTypeDotEnv:
+		c.reader = NewDotEnvReader()
+	case ReaderTypeProperties:
+		c.reader = NewPropertiesReader()
+	case ReaderTypeINI:
+		c.reader = NewINIReader()
 	default:
 		return nil
 	}
@@ -4,6 +4,12 @@
 package config
 
 import (
+	stdjson "encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/iTrellis/common/errors"
 	"github.com/iTrellis/common/files"
 )
 
@@ -21,6 +27,14 @@ const (
 	ReaderTypeYAML
 	// ReaderTypeXML xml reader type
 	ReaderTypeXML
+	// ReaderTypeTOML toml reader type
+	ReaderTypeTOML
+	// ReaderTypeDotEnv .env reader type
+	ReaderTypeDotEnv
+	// ReaderTypeProperties java .properties reader type
+	ReaderTypeProperties
+	// ReaderTypeINI ini reader type
+	ReaderTypeINI
 )
 
 // Reader reader repo
@@ -36,9 +50,15 @@ type Reader interface {
 // ReaderOptionFunc declare reader option function
 type ReaderOptionFunc func(*ReaderOptions)
 
-//ReaderOptions reader options
+// ReaderOptions reader options
 type ReaderOptions struct {
 	filename string
+
+	xmlRoot       string
+	xmlAttrPrefix string
+	xmlTextKey    string
+
+	dotEnvSeparator string
 }
 
 // ReaderOptionFilename set reader filename
@@ -48,6 +68,35 @@ func ReaderOptionFilename(filename string) ReaderOptionFunc {
 	}
 }
 
+// ReaderOptionXMLRoot set xml root element's name for dumping,
+// default: the parsed root element's name, or "config"
+func ReaderOptionXMLRoot(name string) ReaderOptionFunc {
+	return func(opts *ReaderOptions) {
+		opts.xmlRoot = name
+	}
+}
+
+// ReaderOptionXMLAttrPrefix set the prefix of xml attributes' keys, default: "@"
+func ReaderOptionXMLAttrPrefix(prefix string) ReaderOptionFunc {
+	return func(opts *ReaderOptions) {
+		opts.xmlAttrPrefix = prefix
+	}
+}
+
+// ReaderOptionXMLTextKey set the key of xml elements' text, default: "#text"
+func ReaderOptionXMLTextKey(key string) ReaderOptionFunc {
+	return func(opts *ReaderOptions) {
+		opts.xmlTextKey = key
+	}
+}
+
+// ReaderOptionDotEnvSeparator set the separator of .env nested keys, default: "__"
+func ReaderOptionDotEnvSeparator(sep string) ReaderOptionFunc {
+	return func(opts *ReaderOptions) {
+		opts.dotEnvSeparator = sep
+	}
+}
+
 // NewReader return a reader by ReaderType
 func NewReader(rt ReaderType, filename string) (Reader, error) {
 	switch rt {
@@ -57,11 +106,28 @@ func NewReader(rt ReaderType, filename string) (Reader, error) {
 		return NewXMLReader(ReaderOptionFilename(filename)), nil
 	case ReaderTypeYAML:
 		return NewYAMLReader(ReaderOptionFilename(filename)), nil
+	case ReaderTypeTOML:
+		return NewTOMLReader(ReaderOptionFilename(filename)), nil
+	case ReaderTypeDotEnv:
+		return NewDotEnvReader(ReaderOptionFilename(filename)), nil
+	case ReaderTypeProperties:
+		return NewPropertiesReader(ReaderOptionFilename(filename)), nil
+	case ReaderTypeINI:
+		return NewINIReader(ReaderOptionFilename(filename)), nil
 	default:
 		return nil, ErrNotSupportedReaderType
 	}
 }
 
+// readFile read file's data, and close it for reading again
+func readFile(name string) ([]byte, error) {
+	data, _, err := filesRepo.Read(name)
+	if err != nil {
+		return nil, err
+	}
+	return data, filesRepo.Close(name)
+}
+
 /*
 SPACE (\u0020)
 NO-BREAK SPACE (\u00A0)
@@ -81,16 +147,174 @@ NARROW NO-BREAK SPACE (\u202F)
 MEDIUM MATHEMATICAL SPACE (\u205F)
 and IDEOGRAPHIC SPACE (\u3000)
 Byte Order Mark (\uFEFF)
+LINE SEPARATOR (\u2028)
+PARAGRAPH SEPARATOR (\u2029)
 */
-func isWhitespace(c byte) bool {
-	switch string(c) {
-	case " ", "\t", "\n", "\u000B", "\u000C",
-		"\u000D", "\u00A0", "\u1680", "\u2000",
-		"\u2001", "\u2002", "\u2003", "\u2004",
-		"\u2005", "\u2006", "\u2007", "\u2008",
-		"\u2009", "\u200A", "\u202F", "\u205F",
-		"\u2060", "\u3000", "\uFEFF":
+func isWhitespace(r rune) bool {
+	switch r {
+	case ' ', '\t', '\n', '\u000B', '\u000C',
+		'\u000D', '\u00A0', '\u1680', '\u2000',
+		'\u2001', '\u2002', '\u2003', '\u2004',
+		'\u2005', '\u2006', '\u2007', '\u2008',
+		'\u2009', '\u200A', '\u202F', '\u205F',
+		'\u2060', '\u3000', '\uFEFF',
+		'\u2028', '\u2029':
 		return true
 	}
 	return false
 }
+
+// flatTree builder of nested maps from flat keys' tokens, exp: a.b=1 => {"a": {"b": "1"}},
+// maps with keys 0..n-1 are lists
+type flatTree struct {
+	root      map[string]interface{}
+	positions map[string]Position
+}
+
+func newFlatTree() *flatTree {
+	return &flatTree{root: make(map[string]interface{}), positions: make(map[string]Position)}
+}
+
+// set the value of the tokens, the later value overrides the former one
+func (p *flatTree) set(tokens []string, value interface{}, pos Position) error {
+	m := p.root
+	for i, t := range tokens[:len(tokens)-1] {
+		child, ok := m[t]
+		if !ok {
+			child = make(map[string]interface{})
+			m[t] = child
+			p.positions[joinKey(tokens[:i+1])] = pos
+		}
+		cm, ok := child.(map[string]interface{})
+		if !ok {
+			return &ParseError{Position: pos,
+				Err: errors.Newf("key %s conflicts with the value of %s", joinKey(tokens), joinKey(tokens[:i+1]))}
+		}
+		m = cm
+	}
+
+	name := tokens[len(tokens)-1]
+	if _, isMap := m[name].(map[string]interface{}); isMap {
+		if _, valueIsMap := value.(map[string]interface{}); !valueIsMap {
+			return &ParseError{Position: pos, Err: errors.Newf("key %s conflicts with its nested keys", joinKey(tokens))}
+		}
+		return nil
+	}
+	m[name] = value
+	p.positions[joinKey(tokens)] = pos
+	return nil
+}
+
+// configs return the nested maps
+func (p *flatTree) configs() map[string]interface{} {
+	return indexedLists(p.root).(map[string]interface{})
+}
+
+// indexedLists convert the maps with keys 0..n-1 into lists, except the root
+func indexedLists(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	for k, item := range m {
+		if im, ok := item.(map[string]interface{}); ok {
+			m[k] = indexedList(indexedLists(im).(map[string]interface{}))
+		}
+	}
+	return m
+}
+
+func indexedList(m map[string]interface{}) interface{} {
+	if len(m) == 0 {
+		return m
+	}
+	list := make([]interface{}, len(m))
+	for k, item := range m {
+		i, err := strconv.Atoi(k)
+		if err != nil || i < 0 || i >= len(m) || strconv.Itoa(i) != k {
+			return m
+		}
+		list[i] = item
+	}
+	return list
+}
+
+// dumpMap return the map to dump, structs are converted into maps by json
+func dumpMap(v interface{}) (map[string]interface{}, error) {
+	if m, ok := toStringMap(v); ok {
+		return m, nil
+	}
+	var m map[string]interface{}
+	bs, err := stdjson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err = stdjson.Unmarshal(bs, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// flatValue a leaf value's tokens and value in string
+type flatValue struct {
+	tokens []string
+	value  string
+}
+
+// flatValues return the leaf values of v in order of keys, lists' items are keyed by indexes
+func flatValues(v interface{}) ([]flatValue, error) {
+	m, err := dumpMap(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []flatValue
+	flattenValues(&values, nil, m)
+	sort.Slice(values, func(i, j int) bool {
+		return lessTokens(values[i].tokens, values[j].tokens)
+	})
+	return values, nil
+}
+
+// lessTokens compare the tokens one by one, indexes are compared by numbers
+func lessTokens(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		x, errX := strconv.Atoi(a[i])
+		y, errY := strconv.Atoi(b[i])
+		if errX == nil && errY == nil {
+			return x < y
+		}
+		return a[i] < b[i]
+	}
+	return len(a) < len(b)
+}
+
+func flattenValues(values *[]flatValue, tokens []string, v interface{}) {
+	child := func(k string) []string {
+		return append(append([]string{}, tokens...), k)
+	}
+
+	if m, ok := toStringMap(v); ok {
+		for k, item := range m {
+			flattenValues(values, child(k), item)
+		}
+		return
+	}
+	if l, err := convertList(v); err == nil {
+		for i, item := range l {
+			flattenValues(values, child(strconv.Itoa(i)), item)
+		}
+		return
+	}
+	if len(tokens) == 0 {
+		return
+	}
+	s := ""
+	if v != nil {
+		s = fmt.Sprint(v)
+	}
+	*values = append(*values, flatValue{tokens: tokens, value: s})
+}
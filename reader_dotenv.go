@@ -0,0 +1,363 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"os"
+	"strings"
+
+	"github.com/iTrellis/common/errors"
+)
+
+const defDotEnvSeparator = "__"
+
+type defDotEnvReader struct {
+	opts ReaderOptions
+}
+
+// NewDotEnvReader return a .env reader, keys are split into nested keys by the separator,
+// exp: DB__HOST=localhost => {"DB": {"HOST": "localhost"}}
+func NewDotEnvReader(opts ...ReaderOptionFunc) Reader {
+	r := &defDotEnvReader{}
+	for _, o := range opts {
+		o(&r.opts)
+	}
+	return r
+}
+
+func (p *defDotEnvReader) separator() string {
+	if p.opts.dotEnvSeparator == "" {
+		return defDotEnvSeparator
+	}
+	return p.opts.dotEnvSeparator
+}
+
+func (p *defDotEnvReader) Read(model interface{}) error {
+	data, err := readFile(p.opts.filename)
+	if err != nil {
+		return err
+	}
+	return p.ParseData(data, model)
+}
+
+func (p *defDotEnvReader) ParseData(data []byte, model interface{}) error {
+	_, err := p.ParseDataPositions(data, model)
+	return err
+}
+
+// ParseDataPositions parse data to model, and return the keys' positions
+func (p *defDotEnvReader) ParseDataPositions(data []byte, model interface{}) (map[string]Position, error) {
+	entries, err := parseDotEnv(p.opts.filename, data)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := newFlatTree()
+	for _, e := range entries {
+		if err = tree.set(strings.Split(e.name, p.separator()), e.value, e.position); err != nil {
+			return nil, err
+		}
+	}
+	return tree.positions, setJSONValue(tree.configs(), model)
+}
+
+// Dump dump the configs into KEY=VALUE lines in order of keys,
+// nested keys are joined by the separator, lists' items are keyed by indexes
+func (p *defDotEnvReader) Dump(v interface{}) ([]byte, error) {
+	values, err := flatValues(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	for _, fv := range values {
+		sb.WriteString(strings.Join(fv.tokens, p.separator()))
+		sb.WriteByte('=')
+		sb.WriteString(quoteDotEnv(fv.value))
+		sb.WriteByte('\n')
+	}
+	return []byte(sb.String()), nil
+}
+
+// quoteDotEnv quote the value if it has spaces or special characters
+func quoteDotEnv(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\r\n#\"'\\$=`") {
+		return s
+	}
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\r", "\\r", "\t", "\\t", "$", "\\$")
+	return "\"" + r.Replace(s) + "\""
+}
+
+// dotEnvEntry a variable of .env data
+type dotEnvEntry struct {
+	name     string
+	value    string
+	position Position
+}
+
+// loadDotEnv read the .env files in order, return the variables, the later one has the higher precedence
+func loadDotEnv(files []string) (map[string]string, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	vars := make(map[string]string)
+	for _, f := range files {
+		data, err := readFile(f)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseDotEnv(f, data)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			vars[e.name] = e.value
+		}
+	}
+	return vars, nil
+}
+
+// parseDotEnv parse the variables of .env data: KEY=VALUE lines with optional export prefix,
+// comments, single quoted literal values, double quoted values with escapes,
+// values in quotes can be multi-line, ${VAR}, ${VAR:-default} and $VAR are expanded
+// with the variables defined above, or the environment
+func parseDotEnv(file string, data []byte) ([]dotEnvEntry, error) {
+	p := &dotEnvParser{lines: newSourceLines(file, data), data: string(data), vars: make(map[string]string)}
+	return p.parse()
+}
+
+type dotEnvParser struct {
+	lines  *sourceLines
+	data   string
+	offset int
+	vars   map[string]string
+}
+
+func (p *dotEnvParser) errorf(offset int, format string, args ...interface{}) error {
+	return &ParseError{Position: p.lines.position(offset), Err: errors.Newf(format, args...)}
+}
+
+func (p *dotEnvParser) skipBlanks() {
+	for p.offset < len(p.data) && (p.data[p.offset] == ' ' || p.data[p.offset] == '\t') {
+		p.offset++
+	}
+}
+
+// skipLine skip the rest of the line, which can only be blanks or a comment
+func (p *dotEnvParser) skipLine() error {
+	p.skipBlanks()
+	if p.offset < len(p.data) && p.data[p.offset] == '#' {
+		for p.offset < len(p.data) && p.data[p.offset] != '\n' {
+			p.offset++
+		}
+	}
+	if p.offset < len(p.data) && p.data[p.offset] == '\r' {
+		p.offset++
+	}
+	if p.offset < len(p.data) {
+		if p.data[p.offset] != '\n' {
+			return p.errorf(p.offset, "unexpected character %q after value", p.data[p.offset])
+		}
+		p.offset++
+	}
+	return nil
+}
+
+func (p *dotEnvParser) parse() ([]dotEnvEntry, error) {
+	var entries []dotEnvEntry
+	if strings.HasPrefix(p.data, "\uFEFF") {
+		p.offset = len("\uFEFF")
+	}
+
+	for p.offset < len(p.data) {
+		for p.offset < len(p.data) && strings.IndexByte(" \t\r\n", p.data[p.offset]) >= 0 {
+			p.offset++
+		}
+		if p.offset >= len(p.data) {
+			break
+		}
+		if p.data[p.offset] == '#' {
+			if err := p.skipLine(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		start := p.offset
+		name := p.name()
+		if name == "export" && p.offset < len(p.data) && (p.data[p.offset] == ' ' || p.data[p.offset] == '\t') {
+			p.skipBlanks()
+			start = p.offset
+			name = p.name()
+		}
+		if name == "" {
+			return nil, p.errorf(p.offset, "invalid variable name")
+		}
+
+		p.skipBlanks()
+		if p.offset >= len(p.data) || p.data[p.offset] != '=' {
+			return nil, p.errorf(p.offset, "expected '=' after %s", name)
+		}
+		p.offset++
+		p.skipBlanks()
+
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.skipLine(); err != nil {
+			return nil, err
+		}
+
+		p.vars[name] = value
+		entries = append(entries, dotEnvEntry{name: name, value: value, position: p.lines.position(start)})
+	}
+	return entries, nil
+}
+
+func (p *dotEnvParser) name() string {
+	start := p.offset
+	for p.offset < len(p.data) && isDotEnvNameChar(p.data[p.offset]) {
+		p.offset++
+	}
+	return p.data[start:p.offset]
+}
+
+func isDotEnvNameChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+func (p *dotEnvParser) value() (string, error) {
+	if p.offset >= len(p.data) {
+		return "", nil
+	}
+
+	start := p.offset
+	switch p.data[p.offset] {
+	case '\'':
+		end := strings.IndexByte(p.data[p.offset+1:], '\'')
+		if end < 0 {
+			return "", p.errorf(start, "unterminated single quoted value")
+		}
+		p.offset += end + 2
+		return p.data[start+1 : p.offset-1], nil
+	case '"':
+		var sb strings.Builder
+		p.offset++
+		for {
+			if p.offset >= len(p.data) {
+				return "", p.errorf(start, "unterminated double quoted value")
+			}
+			c := p.data[p.offset]
+			switch c {
+			case '"':
+				p.offset++
+				return sb.String(), nil
+			case '\\':
+				if p.offset+1 < len(p.data) {
+					p.offset++
+					switch e := p.data[p.offset]; e {
+					case 'n':
+						sb.WriteByte('\n')
+					case 'r':
+						sb.WriteByte('\r')
+					case 't':
+						sb.WriteByte('\t')
+					case '"', '\\', '$', '`':
+						sb.WriteByte(e)
+					default:
+						sb.WriteByte('\\')
+						sb.WriteByte(e)
+					}
+					p.offset++
+					continue
+				}
+			case '$':
+				s, err := p.expand()
+				if err != nil {
+					return "", err
+				}
+				sb.WriteString(s)
+				continue
+			}
+			sb.WriteByte(c)
+			p.offset++
+		}
+	}
+
+	// unquoted values end at the line's end, or a comment after blanks
+	var sb strings.Builder
+	for p.offset < len(p.data) && p.data[p.offset] != '\n' {
+		c := p.data[p.offset]
+		if c == '#' && p.offset > start && (p.data[p.offset-1] == ' ' || p.data[p.offset-1] == '\t') {
+			break
+		}
+		if c == '$' {
+			s, err := p.expand()
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(s)
+			continue
+		}
+		sb.WriteByte(c)
+		p.offset++
+	}
+	return strings.TrimRight(sb.String(), " \t\r"), nil
+}
+
+// expand return the value of ${VAR}, ${VAR:-default} or $VAR at the offset
+func (p *dotEnvParser) expand() (string, error) {
+	start := p.offset
+	p.offset++
+	if p.offset < len(p.data) && p.data[p.offset] == '{' {
+		end := strings.IndexByte(p.data[p.offset:], '}')
+		if end < 0 {
+			return "", p.errorf(start, "unclosed reference ${")
+		}
+		ref := p.data[p.offset+1 : p.offset+end]
+		p.offset += end + 1
+
+		name, def := ref, ""
+		if i := strings.Index(ref, ":-"); i >= 0 {
+			name, def = ref[:i], ref[i+2:]
+		}
+		if v := p.lookup(name); v != "" {
+			return v, nil
+		}
+		return def, nil
+	}
+
+	for p.offset < len(p.data) && isDotEnvNameChar(p.data[p.offset]) &&
+		p.data[p.offset] != '.' && p.data[p.offset] != '-' {
+		p.offset++
+	}
+	if p.offset == start+1 {
+		return "$", nil
+	}
+	return p.lookup(p.data[start+1 : p.offset]), nil
+}
+
+// lookup return the variable defined above, or the environment's
+func (p *dotEnvParser) lookup(name string) string {
+	if v, ok := p.vars[name]; ok {
+		return v
+	}
+	return os.Getenv(name)
+}
@@ -0,0 +1,250 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iTrellis/common/errors"
+)
+
+type defINIReader struct {
+	opts ReaderOptions
+}
+
+// NewINIReader return an ini reader, sections are top-level maps, and "section.sub" are nested,
+// exp: [db] host=localhost => {"db": {"host": "localhost"}}
+func NewINIReader(opts ...ReaderOptionFunc) Reader {
+	r := &defINIReader{}
+	for _, o := range opts {
+		o(&r.opts)
+	}
+	return r
+}
+
+func (p *defINIReader) Read(model interface{}) error {
+	data, err := readFile(p.opts.filename)
+	if err != nil {
+		return err
+	}
+	return p.ParseData(data, model)
+}
+
+func (p *defINIReader) ParseData(data []byte, model interface{}) error {
+	_, err := p.ParseDataPositions(data, model)
+	return err
+}
+
+// ParseDataPositions parse data to model, and return the keys' positions
+func (p *defINIReader) ParseDataPositions(data []byte, model interface{}) (map[string]Position, error) {
+	lines := newSourceLines(p.opts.filename, data)
+	s := string(data)
+	offset := 0
+	if strings.HasPrefix(s, "\uFEFF") {
+		offset = len("\uFEFF")
+	}
+
+	tree := newFlatTree()
+	var section []string
+	counts := make(map[string]int)
+	for offset < len(s) {
+		start := offset
+		end := strings.IndexByte(s[offset:], '\n')
+		if end < 0 {
+			end = len(s)
+		} else {
+			end += offset
+		}
+		offset = end + 1
+
+		line := strings.TrimSuffix(s[start:end], "\r")
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || trimmed[0] == ';' || trimmed[0] == '#' {
+			continue
+		}
+		pos := lines.position(start + len(line) - len(trimmed))
+		trimmed = strings.TrimRight(trimmed, " \t")
+
+		// sections
+		if trimmed[0] == '[' {
+			closing := strings.IndexByte(trimmed, ']')
+			if closing < 0 {
+				return nil, &ParseError{Position: pos, Err: errors.New("unclosed section")}
+			}
+			if rest := strings.TrimSpace(trimmed[closing+1:]); rest != "" && rest[0] != ';' && rest[0] != '#' {
+				return nil, &ParseError{Position: pos, Err: errors.Newf("unexpected %q after section", rest)}
+			}
+			section = strings.Split(trimmed[1:closing], ".")
+			for i, t := range section {
+				if section[i] = strings.TrimSpace(t); section[i] == "" {
+					return nil, &ParseError{Position: pos, Err: errors.Newf("invalid section %q", trimmed[:closing+1])}
+				}
+			}
+			if err := tree.set(section, make(map[string]interface{}), pos); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		key, value := trimmed, ""
+		if i := strings.IndexAny(trimmed, "=:"); i >= 0 {
+			key = strings.TrimSpace(trimmed[:i])
+			var err error
+			if value, err = iniValue(strings.TrimSpace(trimmed[i+1:])); err != nil {
+				return nil, &ParseError{Position: pos, Err: err}
+			}
+		}
+		if key == "" {
+			return nil, &ParseError{Position: pos, Err: errors.New("empty key")}
+		}
+
+		tokens := append(append([]string{}, section...), key)
+		// key[] = value appends the value into the list
+		if strings.HasSuffix(key, "[]") {
+			tokens[len(tokens)-1] = strings.TrimSpace(strings.TrimSuffix(key, "[]"))
+			list := joinKey(tokens)
+			tokens = append(tokens, strconv.Itoa(counts[list]))
+			counts[list]++
+		}
+		if err := tree.set(tokens, value, pos); err != nil {
+			return nil, err
+		}
+	}
+	return tree.positions, setJSONValue(tree.configs(), model)
+}
+
+// iniValue return the value without the inline comment, or the unquoted value
+func iniValue(s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+
+	switch s[0] {
+	case '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated single quoted value")
+		}
+		return s[1 : end+1], nil
+	case '"':
+		var sb strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch c := s[i]; c {
+			case '"':
+				return sb.String(), nil
+			case '\\':
+				if i+1 < len(s) {
+					i++
+					switch s[i] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					case 'r':
+						sb.WriteByte('\r')
+					default:
+						sb.WriteByte(s[i])
+					}
+					continue
+				}
+				sb.WriteByte(c)
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return "", errors.New("unterminated double quoted value")
+	}
+
+	// inline comments begin with ';' or '#' after blanks
+	for i := 1; i < len(s); i++ {
+		if (s[i] == ';' || s[i] == '#') && (s[i-1] == ' ' || s[i-1] == '\t') {
+			return strings.TrimRight(s[:i], " \t"), nil
+		}
+	}
+	return s, nil
+}
+
+// Dump dump the configs into ini, values of the top level are above sections,
+// maps are sections named by their keys joined by dots, lists are written as key[] = item
+func (p *defINIReader) Dump(v interface{}) ([]byte, error) {
+	m, err := dumpMap(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	if err = dumpINISection(&sb, nil, m); err != nil {
+		return nil, err
+	}
+	return []byte(sb.String()), nil
+}
+
+func dumpINISection(sb *strings.Builder, section []string, m map[string]interface{}) error {
+	if len(section) > 0 {
+		if sb.Len() > 0 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString("[" + strings.Join(section, ".") + "]\n")
+	}
+
+	var sections []string
+	for _, k := range sortedKeys(m) {
+		if _, ok := toStringMap(m[k]); ok {
+			sections = append(sections, k)
+			continue
+		}
+
+		l, err := convertList(m[k])
+		if err != nil {
+			sb.WriteString(k + " = " + quoteINI(m[k]) + "\n")
+			continue
+		}
+		for _, item := range l {
+			if _, isMap := toStringMap(item); isMap {
+				return errors.Newf("can't dump the list of maps at %s into ini", joinKey(append(section, k)))
+			}
+			if _, err := convertList(item); err == nil {
+				return errors.Newf("can't dump the list of lists at %s into ini", joinKey(append(section, k)))
+			}
+			sb.WriteString(k + "[] = " + quoteINI(item) + "\n")
+		}
+	}
+
+	for _, k := range sections {
+		sm, _ := toStringMap(m[k])
+		if err := dumpINISection(sb, append(append([]string{}, section...), k), sm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quoteINI format the value, quote it if it has blanks around, comments, quotes or line breaks
+func quoteINI(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	s := fmt.Sprint(v)
+	if s == strings.TrimSpace(s) && !strings.ContainsAny(s, ";#\"'\\\r\n") {
+		return s
+	}
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\r", "\\r", "\t", "\\t")
+	return "\"" + r.Replace(s) + "\""
+}
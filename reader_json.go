@@ -19,7 +19,19 @@ package config
 
 import (
 	"bytes"
+	stdjson "encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 
+	"github.com/iTrellis/common/errors"
 	"github.com/iTrellis/common/json"
 )
 
@@ -57,59 +69,818 @@ func ReadJSONFile(filepath string, model interface{}) error {
 	return ParseJSONConfig(data, model)
 }
 
-// ParseJSONConfig 解析Json配置
+// ParseJSONConfig 解析Json配置, 支持JSONC和JSON5: 注释, 末尾逗号, 单引号字符串, 无引号键名, 十六进制数字, Infinity和NaN
 func ParseJSONConfig(data []byte, model interface{}) error {
-	var escaped bool // string value flag, " appear times, odd is false, even is true
-	var comments int // 0 nothing; 1 line; 2 multi line
-	var result []byte
+	_, err := parseJSON("", data, model)
+	return err
+}
 
-	length := len(data)
-	for i, w := 0, 0; i < length; i += w {
-		w = 1
+// ParseDataPositions parse data to model, and return the keys' positions
+func (p *defJSONReader) ParseDataPositions(data []byte, model interface{}) (map[string]Position, error) {
+	return parseJSON(p.opts.filename, data, model)
+}
 
-		switch comments {
-		case 1:
-			if data[i] == '\n' {
-				comments, escaped = 0, false
-			}
-			continue
-		case 2:
-			// */
-			if data[i] == '*' && length != i+1 && data[i+1] == '/' {
-				w = 2
-				comments, escaped = 0, false
-			}
+// parseJSON parse data into model, numbers are json.Number except Infinity and NaN
+func parseJSON(file string, data []byte, model interface{}) (map[string]Position, error) {
+	p := &jsonParser{
+		lines:     newSourceLines(file, data),
+		data:      data,
+		positions: make(map[string]Position),
+	}
+	v, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	if err = setJSONValue(v, model); err != nil {
+		return nil, &ParseError{Position: Position{File: file}, Err: err}
+	}
+	return p.positions, nil
+}
+
+// setJSONValue set the parsed value into model
+func setJSONValue(v interface{}, model interface{}) error {
+	switch m := model.(type) {
+	case *interface{}:
+		*m = v
+		return nil
+	case *map[string]interface{}:
+		if v == nil {
+			return nil
+		}
+		vm, ok := v.(map[string]interface{})
+		if !ok {
+			return errors.Newf("cannot unmarshal %T into map[string]interface {}", v)
+		}
+		if *m == nil {
+			*m = vm
+			return nil
+		}
+		for k, item := range vm {
+			(*m)[k] = item
+		}
+		return nil
+	}
+
+	bs, err := stdjson.Marshal(v)
+	if err != nil {
+		return err
+	}
+	decoder := stdjson.NewDecoder(bytes.NewReader(bs))
+	decoder.UseNumber()
+	return decoder.Decode(model)
+}
+
+// jsonParser parser of json with comments and json5's features
+type jsonParser struct {
+	lines     *sourceLines
+	data      []byte
+	offset    int
+	positions map[string]Position
+	spans     map[string]*jsonSpan
+}
+
+// jsonSpan the offsets of a value in data,
+// and of the first member's key (or item) and the last member's end for objects and arrays
+type jsonSpan struct {
+	start, end  int
+	items       int
+	first, last int
+}
+
+func (p *jsonParser) errorf(offset int, format string, args ...interface{}) error {
+	return &ParseError{Position: p.lines.position(offset), Err: errors.Newf(format, args...)}
+}
+
+func (p *jsonParser) parse() (interface{}, error) {
+	if err := p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	v, err := p.value("")
+	if err != nil {
+		return nil, err
+	}
+	if err = p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	if p.offset < len(p.data) {
+		return nil, p.unexpected()
+	}
+	return v, nil
+}
+
+// unexpected return the error of the current character
+func (p *jsonParser) unexpected() error {
+	if p.offset >= len(p.data) {
+		return p.errorf(p.offset, "unexpected end of input")
+	}
+	r, _ := utf8.DecodeRune(p.data[p.offset:])
+	return p.errorf(p.offset, "unexpected character %q", r)
+}
+
+// skipSpaces skip whitespaces and comments
+func (p *jsonParser) skipSpaces() error {
+	for p.offset < len(p.data) {
+		r, size := utf8.DecodeRune(p.data[p.offset:])
+		if isWhitespace(r) {
+			p.offset += size
 			continue
 		}
+		if r != '/' || p.offset+1 >= len(p.data) {
+			return nil
+		}
 
-		switch data[i] {
-		case '"':
-			escaped = !escaped
-			result = append(result, data[i])
+		switch p.data[p.offset+1] {
 		case '/':
-			if escaped || length == i+1 {
-				result = append(result, data[i])
-				continue
-			}
-			switch data[i+1] {
-			case '/':
-				w = 2
-				comments = 1
-			case '*':
-				w = 2
-				comments = 2
-			default:
-				result = append(result, data[i])
+			end := bytes.IndexByte(p.data[p.offset:], '\n')
+			if end < 0 {
+				p.offset = len(p.data)
+				return nil
+			}
+			p.offset += end + 1
+		case '*':
+			end := bytes.Index(p.data[p.offset+2:], []byte("*/"))
+			if end < 0 {
+				return p.errorf(p.offset, "unterminated comment")
 			}
+			p.offset += end + 4
 		default:
-			if escaped || !isWhitespace(data[i]) {
-				result = append(result, data[i])
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *jsonParser) value(key string) (interface{}, error) {
+	if p.offset >= len(p.data) {
+		return nil, p.unexpected()
+	}
+
+	if p.spans != nil {
+		start := p.offset
+		span := &jsonSpan{}
+		p.spans[key] = span
+		defer func() { span.start, span.end = start, p.offset }()
+	}
+
+	switch c := p.data[p.offset]; {
+	case c == '{':
+		return p.object(key)
+	case c == '[':
+		return p.array(key)
+	case c == '"' || c == '\'':
+		return p.string()
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9') || c == 'I' || c == 'N':
+		return p.number()
+	case p.word("true"):
+		return true, nil
+	case p.word("false"):
+		return false, nil
+	case p.word("null"):
+		return nil, nil
+	}
+	return nil, p.unexpected()
+}
+
+// word judge if the next identifier is w, and skip it
+func (p *jsonParser) word(w string) bool {
+	if !bytes.HasPrefix(p.data[p.offset:], []byte(w)) {
+		return false
+	}
+	if end := p.offset + len(w); end < len(p.data) {
+		if r, _ := utf8.DecodeRune(p.data[end:]); isIdentifierRune(r) {
+			return false
+		}
+	}
+	p.offset += len(w)
+	return true
+}
+
+func (p *jsonParser) object(key string) (interface{}, error) {
+	start := p.offset
+	p.offset++
+	m := make(map[string]interface{})
+	for {
+		if err := p.skipSpaces(); err != nil {
+			return nil, err
+		}
+		if p.offset >= len(p.data) {
+			return nil, p.errorf(start, "unterminated object")
+		}
+		if p.data[p.offset] == '}' {
+			p.offset++
+			return m, nil
+		}
+
+		keyStart := p.offset
+		pos := p.lines.position(keyStart)
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.skipSpaces(); err != nil {
+			return nil, err
+		}
+		if p.offset >= len(p.data) || p.data[p.offset] != ':' {
+			return nil, p.errorf(p.offset, "expected ':' after key %q", name)
+		}
+		p.offset++
+		if err = p.skipSpaces(); err != nil {
+			return nil, err
+		}
+
+		k := childKey(key, name)
+		p.positions[k] = pos
+		if m[name], err = p.value(k); err != nil {
+			return nil, err
+		}
+		p.spanItem(key, keyStart)
+
+		if err = p.skipSpaces(); err != nil {
+			return nil, err
+		}
+		if p.offset >= len(p.data) {
+			return nil, p.errorf(start, "unterminated object")
+		}
+		switch p.data[p.offset] {
+		case ',':
+			p.offset++
+		case '}':
+			p.offset++
+			return m, nil
+		default:
+			return nil, p.errorf(p.offset, "expected ',' or '}' after value of key %q", name)
+		}
+	}
+}
+
+func (p *jsonParser) array(key string) (interface{}, error) {
+	start := p.offset
+	p.offset++
+	l := []interface{}{}
+	for {
+		if err := p.skipSpaces(); err != nil {
+			return nil, err
+		}
+		if p.offset >= len(p.data) {
+			return nil, p.errorf(start, "unterminated array")
+		}
+		if p.data[p.offset] == ']' {
+			p.offset++
+			return l, nil
+		}
+
+		k := childKey(key, strconv.Itoa(len(l)))
+		itemStart := p.offset
+		p.positions[k] = p.lines.position(itemStart)
+		v, err := p.value(k)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, v)
+		p.spanItem(key, itemStart)
+
+		if err = p.skipSpaces(); err != nil {
+			return nil, err
+		}
+		if p.offset >= len(p.data) {
+			return nil, p.errorf(start, "unterminated array")
+		}
+		switch p.data[p.offset] {
+		case ',':
+			p.offset++
+		case ']':
+			p.offset++
+			return l, nil
+		default:
+			return nil, p.errorf(p.offset, "expected ',' or ']' after item %d", len(l)-1)
+		}
+	}
+}
+
+// spanItem record a member or item which starts at offset, and ends at the current offset
+func (p *jsonParser) spanItem(key string, start int) {
+	if p.spans == nil {
+		return
+	}
+	span := p.spans[key]
+	if span.items == 0 {
+		span.first = start
+	}
+	span.items++
+	span.last = p.offset
+}
+
+// name parse the key of an object, in quotes or an identifier
+func (p *jsonParser) name() (string, error) {
+	if c := p.data[p.offset]; c == '"' || c == '\'' {
+		return p.string()
+	}
+
+	start := p.offset
+	for p.offset < len(p.data) {
+		r, size := utf8.DecodeRune(p.data[p.offset:])
+		if !isIdentifierRune(r) || (p.offset == start && unicode.IsDigit(r)) {
+			break
+		}
+		p.offset += size
+	}
+	if p.offset == start {
+		return "", p.unexpected()
+	}
+	return string(p.data[start:p.offset]), nil
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// string parse a string in double or single quotes
+func (p *jsonParser) string() (string, error) {
+	start := p.offset
+	quote := p.data[p.offset]
+	p.offset++
+
+	var sb strings.Builder
+	for {
+		if p.offset >= len(p.data) {
+			return "", p.errorf(start, "unterminated string")
+		}
+		r, size := utf8.DecodeRune(p.data[p.offset:])
+		switch {
+		case r == rune(quote):
+			p.offset++
+			return sb.String(), nil
+		case r == '\n' || r == '\r':
+			return "", p.errorf(start, "unterminated string")
+		case r == '\\':
+			if err := p.escape(&sb); err != nil {
+				return "", err
 			}
+		default:
+			sb.WriteRune(r)
+			p.offset += size
 		}
 	}
+}
 
-	decoder := json.NewDecoder(bytes.NewBuffer(result))
-	decoder.UseNumber()
+// escape parse an escape sequence into sb
+func (p *jsonParser) escape(sb *strings.Builder) error {
+	start := p.offset
+	p.offset++
+	if p.offset >= len(p.data) {
+		return p.errorf(start, "unterminated string")
+	}
 
-	return decoder.Decode(model)
+	r, size := utf8.DecodeRune(p.data[p.offset:])
+	p.offset += size
+	switch r {
+	case 'b':
+		sb.WriteByte('\b')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'n':
+		sb.WriteByte('\n')
+	case 'r':
+		sb.WriteByte('\r')
+	case 't':
+		sb.WriteByte('\t')
+	case 'v':
+		sb.WriteByte('\v')
+	case '0':
+		if p.offset < len(p.data) && p.data[p.offset] >= '0' && p.data[p.offset] <= '9' {
+			return p.errorf(start, "invalid escape \\0%c", p.data[p.offset])
+		}
+		sb.WriteByte(0)
+	case 'x':
+		c, err := p.hex(start, 2)
+		if err != nil {
+			return err
+		}
+		sb.WriteRune(c)
+	case 'u':
+		c, err := p.hex(start, 4)
+		if err != nil {
+			return err
+		}
+		if utf16.IsSurrogate(c) && bytes.HasPrefix(p.data[p.offset:], []byte("\\u")) {
+			saved := p.offset
+			p.offset += 2
+			if c2, err := p.hex(start, 4); err == nil && utf16.DecodeRune(c, c2) != utf8.RuneError {
+				c = utf16.DecodeRune(c, c2)
+			} else {
+				p.offset = saved
+			}
+		}
+		sb.WriteRune(c)
+	case '\r':
+		// line continuation
+		if p.offset < len(p.data) && p.data[p.offset] == '\n' {
+			p.offset++
+		}
+	case '\n', '\u2028', '\u2029':
+		// line continuation
+	case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return p.errorf(start, "invalid escape \\%c", r)
+	default:
+		// ", ', \, / and other characters are themselves
+		sb.WriteRune(r)
+	}
+	return nil
+}
+
+// hex parse n hex digits
+func (p *jsonParser) hex(start, n int) (rune, error) {
+	if p.offset+n > len(p.data) {
+		return 0, p.errorf(start, "invalid escape %s", p.data[start:])
+	}
+	v, err := strconv.ParseUint(string(p.data[p.offset:p.offset+n]), 16, 32)
+	if err != nil {
+		return 0, p.errorf(start, "invalid escape %s", p.data[start:p.offset+n])
+	}
+	p.offset += n
+	return rune(v), nil
+}
+
+// number parse decimal, hex numbers, Infinity and NaN,
+// decimals and hex numbers are returned as json.Number
+func (p *jsonParser) number() (interface{}, error) {
+	start := p.offset
+	negative := false
+	if c := p.data[p.offset]; c == '+' || c == '-' {
+		negative = c == '-'
+		p.offset++
+	}
+
+	switch {
+	case p.word("Infinity"):
+		if negative {
+			return math.Inf(-1), nil
+		}
+		return math.Inf(1), nil
+	case p.word("NaN"):
+		return math.NaN(), nil
+	}
+
+	digits := func() string {
+		begin := p.offset
+		for p.offset < len(p.data) && p.data[p.offset] >= '0' && p.data[p.offset] <= '9' {
+			p.offset++
+		}
+		return string(p.data[begin:p.offset])
+	}
+
+	var sb strings.Builder
+	if negative {
+		sb.WriteByte('-')
+	}
+
+	if bytes.HasPrefix(p.data[p.offset:], []byte("0x")) || bytes.HasPrefix(p.data[p.offset:], []byte("0X")) {
+		p.offset += 2
+		begin := p.offset
+		for p.offset < len(p.data) && isHexDigit(p.data[p.offset]) {
+			p.offset++
+		}
+		v, ok := new(big.Int).SetString(string(p.data[begin:p.offset]), 16)
+		if !ok {
+			return nil, p.errorf(start, "invalid number %s", p.data[start:p.offset])
+		}
+		sb.WriteString(v.String())
+		return p.endNumber(start, sb.String())
+	}
+
+	integer := digits()
+	fraction, hasPoint := "", false
+	if p.offset < len(p.data) && p.data[p.offset] == '.' {
+		p.offset++
+		fraction, hasPoint = digits(), true
+	}
+	if integer == "" && fraction == "" {
+		if !hasPoint && p.offset == start {
+			return nil, p.unexpected()
+		}
+		return nil, p.errorf(start, "invalid number %s", p.data[start:p.offset])
+	}
+	if integer == "" {
+		integer = "0"
+	}
+	sb.WriteString(integer)
+	if fraction != "" {
+		sb.WriteByte('.')
+		sb.WriteString(fraction)
+	}
+
+	if p.offset < len(p.data) && (p.data[p.offset] == 'e' || p.data[p.offset] == 'E') {
+		p.offset++
+		sb.WriteByte('e')
+		if p.offset < len(p.data) && (p.data[p.offset] == '+' || p.data[p.offset] == '-') {
+			sb.WriteByte(p.data[p.offset])
+			p.offset++
+		}
+		exponent := digits()
+		if exponent == "" {
+			return nil, p.errorf(start, "invalid number %s", p.data[start:p.offset])
+		}
+		sb.WriteString(exponent)
+	}
+	return p.endNumber(start, sb.String())
+}
+
+// endNumber check the number is followed by a delimiter
+func (p *jsonParser) endNumber(start int, n string) (interface{}, error) {
+	if p.offset < len(p.data) {
+		if r, _ := utf8.DecodeRune(p.data[p.offset:]); isIdentifierRune(r) || r == '.' {
+			return nil, p.errorf(start, "invalid number %s", p.data[start:p.offset+1])
+		}
+	}
+	return stdjson.Number(n), nil
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// parseDocument return the data to edit, which is edited by the values' spans
+func (p *defJSONReader) parseDocument(data []byte) (interface{}, error) {
+	return data, nil
+}
+
+// editDocument set the values into the data in place, and keep comments and formatting
+func (p *defJSONReader) editDocument(document interface{}, kvs []keyValue) ([]byte, error) {
+	data := document.([]byte)
+	for _, kv := range kvs {
+		var err error
+		if data, err = editJSON(p.opts.filename, data, kv.key, kv.value); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// editJSON set the key's value into data
+func editJSON(file string, data []byte, key string, value interface{}) ([]byte, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		data = []byte("{}\n")
+	}
+	segs, err := parseKeyPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &jsonParser{
+		lines:     newSourceLines(file, data),
+		data:      data,
+		positions: make(map[string]Position),
+		spans:     make(map[string]*jsonSpan),
+	}
+	parent, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	e := &jsonEditor{data: data, spans: p.spans, indent: jsonIndent(data)}
+
+	parentKey := ""
+	for i, seg := range segs {
+		switch t := parent.(type) {
+		case map[string]interface{}:
+			if seg.name == IncludeKey {
+				return nil, ErrIncludeNotEditable
+			}
+			child, ok := t[seg.name]
+			if !ok {
+				v, err := setChildValue(nil, segs[i+1:], value)
+				if err != nil {
+					return nil, err
+				}
+				return e.insert(parentKey, seg.name, v, true)
+			}
+			parentKey, parent = childKey(parentKey, seg.name), child
+		case []interface{}:
+			index, err := listIndex(seg, len(t))
+			if err != nil {
+				return nil, err
+			}
+			if index < 0 || index > len(t) {
+				return nil, ErrIndexOutOfRange
+			}
+			if index == len(t) {
+				v, err := setChildValue(nil, segs[i+1:], value)
+				if err != nil {
+					return nil, err
+				}
+				return e.insert(parentKey, "", v, false)
+			}
+			parentKey, parent = childKey(parentKey, strconv.Itoa(index)), t[index]
+		default:
+			// replace scalar values with a new list or map
+			v, err := setChildValue(nil, segs[i:], value)
+			if err != nil {
+				return nil, err
+			}
+			return e.replace(parentKey, v)
+		}
+	}
+	// replacing the include directive drops the included values
+	if m, ok := parent.(map[string]interface{}); ok {
+		if _, ok = m[IncludeKey]; ok {
+			return nil, ErrIncludeNotEditable
+		}
+	}
+	return e.replace(parentKey, value)
+}
+
+// jsonEditor editor of json data by the values' spans
+type jsonEditor struct {
+	data   []byte
+	spans  map[string]*jsonSpan
+	indent string
+}
+
+// replace the key's value
+func (p *jsonEditor) replace(key string, value interface{}) ([]byte, error) {
+	span := p.spans[key]
+	bs, err := encodeJSON(value, lineIndent(p.data, span.start), p.indent)
+	if err != nil {
+		return nil, err
+	}
+	return p.splice(span.start, span.end, bs), nil
+}
+
+// insert a member into the object, or an item into the array at the key
+func (p *jsonEditor) insert(key, name string, value interface{}, member bool) ([]byte, error) {
+	span := p.spans[key]
+
+	indent := lineIndent(p.data, span.start) + p.indent
+	if span.items > 0 {
+		indent = lineIndent(p.data, span.first)
+	}
+	bs, err := encodeJSON(value, indent, p.indent)
+	if err != nil {
+		return nil, err
+	}
+	if member {
+		quoted, err := encodeJSON(name, "", "")
+		if err != nil {
+			return nil, err
+		}
+		bs = append(append(quoted, ": "...), bs...)
+	}
+
+	inline := !bytes.ContainsRune(p.data[span.start:span.end], '\n')
+	switch {
+	case span.items > 0 && inline:
+		return p.splice(span.last, span.last, append([]byte(", "), bs...)), nil
+	case span.items > 0:
+		return p.splice(span.last, span.last, append([]byte(",\n"+indent), bs...)), nil
+	case inline && span.end-span.start <= 2:
+		return p.splice(span.start+1, span.start+1, append(append([]byte("\n"+indent), bs...), "\n"+lineIndent(p.data, span.start)...)), nil
+	default:
+		return p.splice(span.start+1, span.start+1, append([]byte("\n"+indent), bs...)), nil
+	}
+}
+
+func (p *jsonEditor) splice(start, end int, bs []byte) []byte {
+	data := make([]byte, 0, len(p.data)-(end-start)+len(bs))
+	data = append(data, p.data[:start]...)
+	data = append(data, bs...)
+	return append(data, p.data[end:]...)
+}
+
+// encodeJSON encode value with the prefix and indent, without escaping html,
+// Infinity and NaN are encoded as the JSON5 literals, which are parsed back by ParseJSONConfig
+func encodeJSON(value interface{}, prefix, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	if hasNonFinite(reflect.ValueOf(value)) {
+		if err := encodeJSON5(&buf, reflect.ValueOf(value), prefix, indent); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	encoder := stdjson.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent(prefix, indent)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// hasNonFinite judge if the value has Infinity or NaN, which can't be encoded by encoding/json
+func hasNonFinite(rv reflect.Value) bool {
+	for rv.Kind() == reflect.Interface || rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return math.IsNaN(rv.Float()) || math.IsInf(rv.Float(), 0)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if hasNonFinite(rv.Index(i)) {
+				return true
+			}
+		}
+	case reflect.Map:
+		iter := rv.MapRange()
+		for iter.Next() {
+			if hasNonFinite(iter.Value()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// encodeJSON5 encode the maps and lists which have Infinity or NaN, other values are encoded by encodeJSON
+func encodeJSON5(buf *bytes.Buffer, rv reflect.Value, prefix, indent string) error {
+	for rv.Kind() == reflect.Interface || rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			break
+		}
+		rv = rv.Elem()
+	}
+	if !hasNonFinite(rv) {
+		bs, err := encodeJSON(rv.Interface(), prefix, indent)
+		if err != nil {
+			return err
+		}
+		buf.Write(bs)
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		switch f := rv.Float(); {
+		case math.IsNaN(f):
+			buf.WriteString("NaN")
+		case f < 0:
+			buf.WriteString("-Infinity")
+		default:
+			buf.WriteString("Infinity")
+		}
+	case reflect.Slice, reflect.Array:
+		buf.WriteByte('[')
+		for i := 0; i < rv.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString("\n" + prefix + indent)
+			if err := encodeJSON5(buf, rv.Index(i), prefix+indent, indent); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("\n" + prefix + "]")
+	case reflect.Map:
+		keys := make([]string, 0, rv.Len())
+		values := make(map[string]reflect.Value, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			k := fmt.Sprint(iter.Key().Interface())
+			keys = append(keys, k)
+			values[k] = iter.Value()
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			name, err := encodeJSON(k, "", "")
+			if err != nil {
+				return err
+			}
+			buf.WriteString("\n" + prefix + indent)
+			buf.Write(name)
+			buf.WriteString(": ")
+			if err = encodeJSON5(buf, values[k], prefix+indent, indent); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("\n" + prefix + "}")
+	}
+	return nil
+}
+
+// lineIndent return the leading whitespaces of the line at offset
+func lineIndent(data []byte, offset int) string {
+	start := bytes.LastIndexByte(data[:offset], '\n') + 1
+	end := start
+	for end < len(data) && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[start:end])
+}
+
+// jsonIndent return the indent of the first indented line, default 2 spaces
+func jsonIndent(data []byte) string {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " \t")
+		if n := len(line) - len(trimmed); n > 0 && len(bytes.TrimSpace(trimmed)) > 0 {
+			return string(line[:n])
+		}
+	}
+	return "  "
 }
@@ -0,0 +1,246 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/iTrellis/common/errors"
+)
+
+type defPropertiesReader struct {
+	opts ReaderOptions
+}
+
+// NewPropertiesReader return a java .properties reader, dotted keys are nested keys,
+// exp: db.host=localhost => {"db": {"host": "localhost"}}
+func NewPropertiesReader(opts ...ReaderOptionFunc) Reader {
+	r := &defPropertiesReader{}
+	for _, o := range opts {
+		o(&r.opts)
+	}
+	return r
+}
+
+func (p *defPropertiesReader) Read(model interface{}) error {
+	data, err := readFile(p.opts.filename)
+	if err != nil {
+		return err
+	}
+	return p.ParseData(data, model)
+}
+
+func (p *defPropertiesReader) ParseData(data []byte, model interface{}) error {
+	_, err := p.ParseDataPositions(data, model)
+	return err
+}
+
+// ParseDataPositions parse data to model, and return the keys' positions
+func (p *defPropertiesReader) ParseDataPositions(data []byte, model interface{}) (map[string]Position, error) {
+	lines := newSourceLines(p.opts.filename, data)
+	s := strings.TrimPrefix(string(data), "\uFEFF")
+	base := len(data) - len(s)
+
+	tree := newFlatTree()
+	for offset := 0; offset < len(s); {
+		line, next := propertiesLine(s, offset)
+		start := offset
+		offset = next
+
+		trimmed := strings.TrimLeft(line, " \t\f")
+		if trimmed == "" || trimmed[0] == '#' || trimmed[0] == '!' {
+			continue
+		}
+		pos := lines.position(base + start + len(line) - len(trimmed))
+
+		key, value, err := splitProperty(trimmed)
+		if err != nil {
+			return nil, &ParseError{Position: pos, Err: err}
+		}
+		if key == "" {
+			return nil, &ParseError{Position: pos, Err: errors.New("empty key")}
+		}
+		if err = tree.set(strings.Split(key, "."), value, pos); err != nil {
+			return nil, err
+		}
+	}
+	return tree.positions, setJSONValue(tree.configs(), model)
+}
+
+// propertiesLine return the logical line at offset, and the offset of the next one,
+// lines ending with an odd number of backslashes are continued by the next line without its leading blanks
+func propertiesLine(s string, offset int) (string, int) {
+	var sb strings.Builder
+	for {
+		end := strings.IndexByte(s[offset:], '\n')
+		next := len(s)
+		if end >= 0 {
+			end += offset
+			next = end + 1
+		} else {
+			end = len(s)
+		}
+		line := strings.TrimSuffix(s[offset:end], "\r")
+
+		// comments are not continued
+		trimmed := strings.TrimLeft(line, " \t\f")
+		if sb.Len() == 0 && (strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "!")) {
+			return line, next
+		}
+
+		slashes := len(line) - len(strings.TrimRight(line, "\\"))
+		if slashes%2 == 0 || next >= len(s) {
+			sb.WriteString(line)
+			return sb.String(), next
+		}
+		sb.WriteString(line[:len(line)-1])
+
+		offset = next
+		for offset < len(s) && (s[offset] == ' ' || s[offset] == '\t' || s[offset] == '\f') {
+			offset++
+		}
+	}
+}
+
+// splitProperty split the line into the unescaped key and value,
+// the key ends with unescaped '=', ':' or blanks
+func splitProperty(line string) (string, string, error) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' {
+			i++
+			continue
+		}
+		if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' {
+			end = i
+			break
+		}
+	}
+
+	key, err := unescapeProperty(line[:end])
+	if err != nil {
+		return "", "", err
+	}
+
+	rest := strings.TrimLeft(line[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	value, err := unescapeProperty(rest)
+	return key, value, err
+}
+
+// unescapeProperty replace escapes: \t \n \r \f \uXXXX, others are the escaped characters
+func unescapeProperty(s string) (string, error) {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 >= len(s) {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			sb.WriteByte('\t')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'u':
+			if i+4 >= len(s) {
+				return "", errors.Newf("invalid unicode escape: %s", s[i-1:])
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", errors.Newf("invalid unicode escape: %s", s[i-1:i+5])
+			}
+			i += 4
+			// surrogate pairs
+			if r >= 0xD800 && r < 0xDC00 && i+6 < len(s) && s[i+1] == '\\' && s[i+2] == 'u' {
+				if low, err := strconv.ParseUint(s[i+3:i+7], 16, 16); err == nil && low >= 0xDC00 && low < 0xE000 {
+					r = (r-0xD800)<<10 + (low - 0xDC00) + 0x10000
+					i += 6
+				}
+			}
+			sb.WriteRune(rune(r))
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String(), nil
+}
+
+// Dump dump the configs into key=value lines in order of keys,
+// nested keys are joined by dots, lists' items are keyed by indexes
+func (p *defPropertiesReader) Dump(v interface{}) ([]byte, error) {
+	values, err := flatValues(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	for _, fv := range values {
+		sb.WriteString(escapeProperty(strings.Join(fv.tokens, "."), true))
+		sb.WriteByte('=')
+		sb.WriteString(escapeProperty(fv.value, false))
+		sb.WriteByte('\n')
+	}
+	return []byte(sb.String()), nil
+}
+
+// escapeProperty escape the key or value, non-ASCII characters are kept in UTF-8
+func escapeProperty(s string, key bool) string {
+	var sb strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '=', ':', '#', '!':
+			if key {
+				sb.WriteByte('\\')
+			}
+			sb.WriteRune(r)
+		case ' ':
+			// leading blanks of values are skipped when parsing
+			if key || i == 0 {
+				sb.WriteByte('\\')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
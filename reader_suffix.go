@@ -27,7 +27,7 @@ type defSuffixReader struct {
 }
 
 // NewSuffixReader return a suffix reader
-// supportted: .json, .xml, .yaml, .yml
+// supportted: .json, .xml, .yaml, .yml, .toml, .env, .properties, .ini
 func NewSuffixReader(opts ...ReaderOptionFunc) (reader Reader, err error) {
 	r := &defSuffixReader{}
 
@@ -67,6 +67,14 @@ func fileToReader(filename string) (Reader, error) {
 	case strings.HasSuffix(filename, ".yml"),
 		strings.HasSuffix(filename, ".yaml"):
 		return NewYAMLReader(ReaderOptionFilename(filename)), nil
+	case strings.HasSuffix(filename, ".toml"):
+		return NewTOMLReader(ReaderOptionFilename(filename)), nil
+	case strings.HasSuffix(filename, ".env"):
+		return NewDotEnvReader(ReaderOptionFilename(filename)), nil
+	case strings.HasSuffix(filename, ".properties"):
+		return NewPropertiesReader(ReaderOptionFilename(filename)), nil
+	case strings.HasSuffix(filename, ".ini"):
+		return NewINIReader(ReaderOptionFilename(filename)), nil
 	default:
 		return nil, ErrUnknownSuffixes
 	}
@@ -81,6 +89,14 @@ func fileToReaderType(name string) ReaderType {
 	case strings.HasSuffix(name, ".yml"),
 		strings.HasSuffix(name, ".yaml"):
 		return ReaderTypeYAML
+	case strings.HasSuffix(name, ".toml"):
+		return ReaderTypeTOML
+	case strings.HasSuffix(name, ".env"):
+		return ReaderTypeDotEnv
+	case strings.HasSuffix(name, ".properties"):
+		return ReaderTypeProperties
+	case strings.HasSuffix(name, ".ini"):
+		return ReaderTypeINI
 	default:
 		return ReaderTypeSuffix
 	}
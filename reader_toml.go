@@ -0,0 +1,67 @@
+/*
+Copyright © 2017 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"bytes"
+
+	"github.com/BurntSushi/toml"
+)
+
+type defTOMLReader struct {
+	opts ReaderOptions
+}
+
+// NewTOMLReader return a toml reader
+func NewTOMLReader(opts ...ReaderOptionFunc) Reader {
+	r := &defTOMLReader{}
+	for _, o := range opts {
+		o(&r.opts)
+	}
+	return r
+}
+
+func (p *defTOMLReader) Read(model interface{}) error {
+	data, err := ReadTOMLFile(p.opts.filename)
+	if err != nil {
+		return err
+	}
+	return ParseTOMLConfig(data, model)
+}
+
+func (*defTOMLReader) Dump(v interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := toml.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (*defTOMLReader) ParseData(data []byte, model interface{}) error {
+	return ParseTOMLConfig(data, model)
+}
+
+// ReadTOMLFile 读取toml文件的配置信息
+func ReadTOMLFile(name string) ([]byte, error) {
+	return readFile(name)
+}
+
+// ParseTOMLConfig 解析toml的配置信息
+func ParseTOMLConfig(data []byte, model interface{}) error {
+	return toml.Unmarshal(data, model)
+}
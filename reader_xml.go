@@ -18,19 +18,45 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package config
 
 import (
+	"bytes"
 	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defXMLAttrPrefix = "@"
+	defXMLTextKey    = "#text"
+	defXMLRoot       = "config"
 )
 
 type defXMLReader struct {
 	opts ReaderOptions
+
+	// name of the last parsed root element, for dumping
+	locker sync.RWMutex
+	root   string
 }
 
 // NewXMLReader return xml config reader
+// elements are parsed into nested keys, repeated elements into lists,
+// attributes into keys with prefix "@", and text of elements with attributes or children into key "#text"
 func NewXMLReader(opts ...ReaderOptionFunc) Reader {
 	r := &defXMLReader{}
 	for _, o := range opts {
 		o(&r.opts)
 	}
+	if r.opts.xmlAttrPrefix == "" {
+		r.opts.xmlAttrPrefix = defXMLAttrPrefix
+	}
+	if r.opts.xmlTextKey == "" {
+		r.opts.xmlTextKey = defXMLTextKey
+	}
 	return r
 }
 
@@ -39,18 +65,71 @@ func (p *defXMLReader) Read(model interface{}) error {
 	if err != nil {
 		return err
 	}
-	return ParseXMLConfig(data, model)
+	return p.ParseData(data, model)
 }
 
-func (*defXMLReader) Dump(v interface{}) ([]byte, error) {
-	return xml.Marshal(v)
+func (p *defXMLReader) Dump(v interface{}) ([]byte, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		if opts, isOpts := v.(Options); isOpts {
+			m, ok = opts, true
+		}
+	}
+	if !ok {
+		return xml.Marshal(v)
+	}
+
+	root := p.opts.xmlRoot
+	if root == "" {
+		p.locker.RLock()
+		root = p.root
+		p.locker.RUnlock()
+	}
+	if root == "" {
+		root = defXMLRoot
+	}
+
+	buf := bytes.NewBufferString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+	if err := p.encodeValue(enc, root, m); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
 }
 
-func (*defXMLReader) ParseData(data []byte, model interface{}) error {
+func (p *defXMLReader) ParseData(data []byte, model interface{}) error {
+	switch m := model.(type) {
+	case *map[string]interface{}:
+		tree, err := p.parseTree(data)
+		if err != nil {
+			return err
+		}
+		*m = tree
+		return nil
+	case *Options:
+		tree, err := p.parseTree(data)
+		if err != nil {
+			return err
+		}
+		*m = tree
+		return nil
+	case *interface{}:
+		tree, err := p.parseTree(data)
+		if err != nil {
+			return err
+		}
+		*m = tree
+		return nil
+	}
 	return ParseXMLConfig(data, model)
 }
 
-// ReadXMLFile 读取yaml文件的配置信息
+// ReadXMLFile 读取xml文件的配置信息
 func ReadXMLFile(name string) ([]byte, error) {
 	data, _, err := filesRepo.Read(name)
 	if err != nil {
@@ -59,7 +138,170 @@ func ReadXMLFile(name string) ([]byte, error) {
 	return data, nil
 }
 
-// ParseXMLConfig 解析yaml的配置信息
+// ParseXMLConfig 解析xml的配置信息
 func ParseXMLConfig(data []byte, model interface{}) error {
 	return xml.Unmarshal(data, model)
 }
+
+// parseTree parse the root element's content into map
+func (p *defXMLReader) parseTree(data []byte) (map[string]interface{}, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return make(map[string]interface{}), nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		v, err := p.decodeElement(dec, start)
+		if err != nil {
+			return nil, err
+		}
+
+		p.locker.Lock()
+		p.root = start.Name.Local
+		p.locker.Unlock()
+		if m, ok := v.(map[string]interface{}); ok {
+			return m, nil
+		}
+		return map[string]interface{}{p.opts.xmlTextKey: v}, nil
+	}
+}
+
+// decodeElement decode element into string if it has only text, or into map
+func (p *defXMLReader) decodeElement(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	m := make(map[string]interface{})
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+			continue
+		}
+		m[p.opts.xmlAttrPrefix+attr.Name.Local] = attr.Value
+	}
+
+	lists := make(map[string]bool)
+	var text strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			v, err := p.decodeElement(dec, t)
+			if err != nil {
+				return nil, err
+			}
+
+			name := t.Name.Local
+			exist, ok := m[name]
+			switch {
+			case !ok:
+				m[name] = v
+			case lists[name]:
+				m[name] = append(exist.([]interface{}), v)
+			default:
+				m[name] = []interface{}{exist, v}
+				lists[name] = true
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(m) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				m[p.opts.xmlTextKey] = s
+			}
+			return m, nil
+		}
+	}
+}
+
+// encodeValue encode value into elements named name
+func (p *defXMLReader) encodeValue(enc *xml.Encoder, name string, v interface{}) error {
+	if m, ok := toStringMap(v); ok {
+		return p.encodeMap(enc, name, m)
+	}
+
+	if v != nil {
+		rv := reflect.ValueOf(v)
+		if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < rv.Len(); i++ {
+				if err := p.encodeValue(enc, name, rv.Index(i).Interface()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if v != nil {
+		if err := enc.EncodeToken(xml.CharData(xmlText(v))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+func (p *defXMLReader) encodeMap(enc *xml.Encoder, name string, m map[string]interface{}) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	var children []string
+	for _, k := range keys {
+		switch {
+		case k == p.opts.xmlTextKey:
+		case strings.HasPrefix(k, p.opts.xmlAttrPrefix):
+			start.Attr = append(start.Attr, xml.Attr{
+				Name:  xml.Name{Local: strings.TrimPrefix(k, p.opts.xmlAttrPrefix)},
+				Value: xmlText(m[k]),
+			})
+		default:
+			children = append(children, k)
+		}
+	}
+
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if text, ok := m[p.opts.xmlTextKey]; ok && text != nil {
+		if err := enc.EncodeToken(xml.CharData(xmlText(text))); err != nil {
+			return err
+		}
+	}
+	for _, k := range children {
+		if err := p.encodeValue(enc, k, m[k]); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+func xmlText(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
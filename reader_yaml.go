@@ -18,6 +18,11 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package config
 
 import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
 	"gopkg.in/yaml.v3"
 )
 
@@ -63,3 +68,373 @@ func ReadYAMLFile(name string) ([]byte, error) {
 func ParseYAMLConfig(data []byte, model interface{}) error {
 	return yaml.Unmarshal(data, model)
 }
+
+// ParseDataPositions parse data to model, and return the keys' positions
+func (p *defYamlReader) ParseDataPositions(data []byte, model interface{}) (map[string]Position, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, yamlParseError(p.opts.filename, err)
+	}
+	if node.Kind == 0 {
+		return nil, nil
+	}
+	yamlIncludes(&node)
+	if err := node.Decode(model); err != nil {
+		return nil, yamlParseError(p.opts.filename, err)
+	}
+
+	positions := make(map[string]Position)
+	yamlPositions(p.opts.filename, "", &node, positions)
+	return positions, nil
+}
+
+// yamlIncludes convert the nodes tagged "!include" into maps with IncludeKey
+func yamlIncludes(node *yaml.Node) {
+	if node.Tag == "!include" && (node.Kind == yaml.ScalarNode || node.Kind == yaml.SequenceNode) {
+		value := *node
+		value.Tag = ""
+		*node = yaml.Node{
+			Kind:   yaml.MappingNode,
+			Tag:    "!!map",
+			Line:   node.Line,
+			Column: node.Column,
+			Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Tag: "!!str", Value: IncludeKey, Line: node.Line, Column: node.Column},
+				&value,
+			},
+		}
+		return
+	}
+	for _, n := range node.Content {
+		yamlIncludes(n)
+	}
+}
+
+var yamlLineRegexp = regexp.MustCompile(`line (\d+)`)
+
+// yamlParseError return ParseError with the line in yaml's error
+func yamlParseError(file string, err error) error {
+	pos := Position{File: file}
+	if m := yamlLineRegexp.FindStringSubmatch(err.Error()); m != nil {
+		pos.Line, _ = strconv.Atoi(m[1])
+		pos.Column = 1
+	}
+	return &ParseError{Position: pos, Err: err}
+}
+
+// yamlPositions record the positions of the node's keys
+func yamlPositions(file, key string, node *yaml.Node, positions map[string]Position) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			yamlPositions(file, key, n, positions)
+		}
+	case yaml.AliasNode:
+		yamlPositions(file, key, node.Alias, positions)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name, value := node.Content[i], node.Content[i+1]
+			if name.Tag == "!!merge" {
+				continue
+			}
+			k := childKey(key, name.Value)
+			positions[k] = Position{File: file, Line: name.Line, Column: name.Column}
+			yamlPositions(file, k, value, positions)
+		}
+	case yaml.SequenceNode:
+		for i, n := range node.Content {
+			k := childKey(key, strconv.Itoa(i))
+			positions[k] = Position{File: file, Line: n.Line, Column: n.Column}
+			yamlPositions(file, k, n, positions)
+		}
+	}
+}
+
+// yamlDocument the parsed yaml data to edit
+type yamlDocument struct {
+	data   []byte
+	node   *yaml.Node
+	indent int
+}
+
+// parseDocument parse data into the yaml node tree
+func (p *defYamlReader) parseDocument(data []byte) (interface{}, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, yamlParseError(p.opts.filename, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	return &yamlDocument{data: data, node: &doc, indent: yamlIndent(data)}, nil
+}
+
+// editDocument set the values into a copy of the yaml node tree,
+// and keep comments, order of keys, anchors and blank lines
+func (p *defYamlReader) editDocument(document interface{}, kvs []keyValue) ([]byte, error) {
+	d := document.(*yamlDocument)
+	if len(kvs) == 0 {
+		return d.data, nil
+	}
+
+	doc := copyYAMLTree(d.node, make(map[*yaml.Node]*yaml.Node))
+	for _, kv := range kvs {
+		segs, err := parseKeyPath(kv.key)
+		if err != nil {
+			return nil, err
+		}
+		if doc.Content[0], err = setYAMLNode(doc.Content[0], segs, kv.value); err != nil {
+			return nil, err
+		}
+	}
+
+	clearMergeTags(doc)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(d.indent)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return yamlBlankLines(d.data, buf.Bytes()), nil
+}
+
+// copyYAMLTree return a deep copy of the node, aliases in it refer to the copied anchors
+func copyYAMLTree(node *yaml.Node, copies map[*yaml.Node]*yaml.Node) *yaml.Node {
+	n := *node
+	copies[node] = &n
+	if n.Kind == yaml.AliasNode {
+		if alias, ok := copies[n.Alias]; ok {
+			n.Alias = alias
+		}
+		return &n
+	}
+	n.Content = make([]*yaml.Node, len(node.Content))
+	for i, c := range node.Content {
+		n.Content[i] = copyYAMLTree(c, copies)
+	}
+	return &n
+}
+
+// setYAMLNode set value into node by the segments, return the new node
+func setYAMLNode(node *yaml.Node, segs []keySegment, value interface{}) (*yaml.Node, error) {
+	// the included values are not in the document, and replacing the directive drops them,
+	// but keys can be added beside "$include" to override the included ones
+	if node.Tag == "!include" || (len(segs) == 0 && yamlHasInclude(node)) || (len(segs) > 0 && segs[0].name == IncludeKey) {
+		return nil, ErrIncludeNotEditable
+	}
+	if len(segs) == 0 {
+		return newYAMLNode(node, value)
+	}
+	seg := segs[0]
+
+	// write into a copy of the anchored node, the anchor and other aliases are kept
+	if node.Kind == yaml.AliasNode {
+		alias := node
+		node = copyYAMLNode(alias.Alias)
+		node.HeadComment, node.LineComment, node.FootComment = alias.HeadComment, alias.LineComment, alias.FootComment
+	}
+	target := node
+
+	switch target.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(target.Content); i += 2 {
+			if target.Content[i].Tag == "!!merge" || target.Content[i].Value != seg.name {
+				continue
+			}
+			child, err := setYAMLNode(target.Content[i+1], segs[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			target.Content[i+1] = child
+			return node, nil
+		}
+
+		v, err := setChildValue(nil, segs[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		child, err := newYAMLNode(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		target.Content = append(target.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: seg.name}, child)
+		return node, nil
+	case yaml.SequenceNode:
+		i, err := listIndex(seg, len(target.Content))
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i > len(target.Content) {
+			return nil, ErrIndexOutOfRange
+		}
+		if i < len(target.Content) {
+			child, err := setYAMLNode(target.Content[i], segs[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			target.Content[i] = child
+			return node, nil
+		}
+
+		v, err := setChildValue(nil, segs[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		child, err := newYAMLNode(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		target.Content = append(target.Content, child)
+		return node, nil
+	}
+
+	// replace scalar values with a new list or map
+	v, err := setChildValue(nil, segs, value)
+	if err != nil {
+		return nil, err
+	}
+	return newYAMLNode(node, v)
+}
+
+// yamlHasInclude judge if the node is a map with the include directive
+func yamlHasInclude(node *yaml.Node) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == IncludeKey {
+			return true
+		}
+	}
+	return false
+}
+
+// newYAMLNode encode value into a node, which replaces the old node with its comments, anchor and style
+func newYAMLNode(old *yaml.Node, value interface{}) (*yaml.Node, error) {
+	n := &yaml.Node{}
+	if err := n.Encode(value); err != nil {
+		return nil, err
+	}
+	if old == nil {
+		return n, nil
+	}
+
+	n.HeadComment, n.LineComment, n.FootComment = old.HeadComment, old.LineComment, old.FootComment
+	n.Anchor = old.Anchor
+	if n.Kind == old.Kind && n.Tag == old.Tag && n.Kind == yaml.ScalarNode {
+		n.Style = old.Style
+	}
+	return n, nil
+}
+
+// copyYAMLNode return a deep copy of the node without anchors, aliases in it are kept
+func copyYAMLNode(node *yaml.Node) *yaml.Node {
+	n := *node
+	n.Anchor = ""
+	if n.Kind == yaml.AliasNode {
+		return &n
+	}
+	n.Content = make([]*yaml.Node, len(node.Content))
+	for i, c := range node.Content {
+		n.Content[i] = copyYAMLNode(c)
+	}
+	return &n
+}
+
+// maxBlankLinesCells the max size of the table to match lines for restoring blank lines
+const maxBlankLinesCells = 1 << 22
+
+// yamlBlankLines restore the blank lines of data into the encoded out, which are dropped by yaml.v3,
+// lines of out are matched with data's by their longest common subsequence,
+// and the blank lines before a line of data are inserted before its matched line
+func yamlBlankLines(data, out []byte) []byte {
+	var lines []string
+	var blanks []int
+	n := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			n++
+			continue
+		}
+		lines, blanks, n = append(lines, yamlLineKey(line)), append(blanks, n), 0
+	}
+
+	outLines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	outKeys := make([]string, len(outLines))
+	for j, line := range outLines {
+		outKeys[j] = yamlLineKey(line)
+	}
+	rows, cols := len(lines)+1, len(outLines)+1
+	if rows*cols > maxBlankLinesCells {
+		return out
+	}
+
+	// lcs[i*cols+j] is the length of the longest common subsequence of lines[i:] and outLines[j:]
+	lcs := make([]int32, rows*cols)
+	for i := len(lines) - 1; i >= 0; i-- {
+		for j := len(outLines) - 1; j >= 0; j-- {
+			switch {
+			case lines[i] == outKeys[j]:
+				lcs[i*cols+j] = lcs[(i+1)*cols+j+1] + 1
+			case lcs[(i+1)*cols+j] >= lcs[i*cols+j+1]:
+				lcs[i*cols+j] = lcs[(i+1)*cols+j]
+			default:
+				lcs[i*cols+j] = lcs[i*cols+j+1]
+			}
+		}
+	}
+
+	var sb strings.Builder
+	for i, j := 0, 0; j < len(outLines); j++ {
+		for i < len(lines) && lines[i] != outKeys[j] && lcs[(i+1)*cols+j] >= lcs[i*cols+j+1] {
+			i++
+		}
+		if i < len(lines) && lines[i] == outKeys[j] {
+			if j > 0 && strings.TrimSpace(outLines[j-1]) != "" {
+				sb.WriteString(strings.Repeat("\n", blanks[i]))
+			}
+			i++
+		}
+		sb.WriteString(outLines[j])
+		sb.WriteByte('\n')
+	}
+	return []byte(sb.String())
+}
+
+// yamlLineKey return the line without blanks around and the value of its key, to match the edited lines,
+// exp: "  - port: 80 # http" => "- port:"
+func yamlLineKey(line string) string {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "#") {
+		return line
+	}
+	if i := strings.Index(line, ": "); i >= 0 {
+		return line[:i+1]
+	}
+	return line
+}
+
+// clearMergeTags clear tags of merge keys, which are encoded as "!!merge <<" by yaml.v3
+func clearMergeTags(node *yaml.Node) {
+	if node.Tag == "!!merge" {
+		node.Tag = ""
+	}
+	for _, n := range node.Content {
+		clearMergeTags(n)
+	}
+}
+
+// yamlIndent return the indent of the first indented line, default 2
+func yamlIndent(data []byte) int {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " ")
+		if n := len(line) - len(trimmed); n > 0 && len(trimmed) > 0 && trimmed[0] != '#' && trimmed[0] != '-' {
+			return n
+		}
+	}
+	return 2
+}